@@ -0,0 +1,255 @@
+package autobuild
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Config is the content of a config file: the targets and the settings
+// shared by all of them.
+type Config struct {
+	Targets []TargetConfig `json:"targets"`
+	ModSync []string       `json:"mod_sync"`
+
+	Profile  string             `json:"profile"`
+	Profiles map[string]Profile `json:"profiles"`
+
+	Notify NotifyConfig `json:"notify"`
+}
+
+// TargetConfig describes how to build and run one target.
+type TargetConfig struct {
+	Name  string    `json:"name"`
+	Pkg   string    `json:"pkg"`
+	Build string    `json:"build"`
+	Run   string    `json:"run"`
+	Args  []string  `json:"args"`
+	Watch WatchRule `json:"watch"`
+
+	Env      EnvConfig `json:"env"`
+	BuildEnv EnvConfig `json:"build_env"`
+
+	DependsOn []Dependency `json:"depends_on"`
+	Ready     ReadyCheck   `json:"ready"`
+	Hooks     Hooks        `json:"hooks"`
+
+	// Debug is the listen address of a headless delve server the
+	// target runs under, empty runs it directly.
+	Debug string `json:"debug"`
+
+	// Exec is a short-lived command run on every change instead of
+	// building and running a program, go vet ./... for example.
+	Exec string `json:"exec"`
+}
+
+// Dependency makes a target wait for another one before starting.
+// Condition is "started" or "ready" (default). With Cascade set, the
+// target is restarted whenever the dependency restarts.
+type Dependency struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	Cascade   bool   `json:"cascade"`
+}
+
+// ReadyCheck tells when a started target is ready to serve its
+// dependents. Without any check a target is ready once started.
+type ReadyCheck struct {
+	TCP     string `json:"tcp"`
+	HTTP    string `json:"http"`
+	Log     string `json:"log"`
+	Delay   string `json:"delay"`
+	Timeout string `json:"timeout"`
+}
+
+// WatchRule limits which changes trigger a rebuild of a target.
+type WatchRule struct {
+	Dirs   []string `json:"dirs"`
+	Exts   []string `json:"exts"`
+	Ignore []string `json:"ignore"`
+}
+
+// LoadConfig reads and validates a config file. Lines may end in //
+// comments.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(stripComments(data), &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets defined", path)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// validate checks the config and sorts its targets in start order.
+func (cfg *Config) validate() error {
+	names := make(map[string]bool)
+	for _, t := range cfg.Targets {
+		if t.Name == "" && len(cfg.Targets) > 1 {
+			return fmt.Errorf("target without name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target %q", t.Name)
+		}
+		names[t.Name] = true
+
+		for _, v := range []string{t.Ready.Delay, t.Ready.Timeout} {
+			if v == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(v); err != nil {
+				return fmt.Errorf("target %q: %v", t.Name, err)
+			}
+		}
+
+		for _, e := range []EnvConfig{t.Env, t.BuildEnv} {
+			if err := e.validate(); err != nil {
+				return fmt.Errorf("target %q: %v", t.Name, err)
+			}
+		}
+
+		if err := t.Hooks.validate(); err != nil {
+			return fmt.Errorf("target %q: %v", t.Name, err)
+		}
+
+		if err := t.checkCommands(); err != nil {
+			return fmt.Errorf("target %q: %v", t.Name, err)
+		}
+
+		if err := t.checkExec(); err != nil {
+			return fmt.Errorf("target %q: %v", t.Name, err)
+		}
+	}
+
+	execs := make(map[string]bool)
+	for _, t := range cfg.Targets {
+		execs[t.Name] = t.Exec != ""
+	}
+
+	for _, t := range cfg.Targets {
+		for _, d := range t.DependsOn {
+			if !names[d.Name] {
+				return fmt.Errorf("target %q depends on unknown target %q", t.Name, d.Name)
+			}
+			if execs[d.Name] {
+				return fmt.Errorf("target %q depends on exec target %q", t.Name, d.Name)
+			}
+			switch d.Condition {
+			case "", "started", "ready":
+			default:
+				return fmt.Errorf("target %q: unknown condition %q", t.Name, d.Condition)
+			}
+		}
+	}
+
+	if err := checkModSync(cfg.ModSync); err != nil {
+		return err
+	}
+
+	if err := cfg.Notify.validate(); err != nil {
+		return err
+	}
+
+	sorted, err := sortTargets(cfg.Targets)
+	if err != nil {
+		return err
+	}
+	cfg.Targets = sorted
+	return nil
+}
+
+// checkCommands rejects blank commands and a debugger for a custom run
+// command, delve starts the built binary itself.
+func (c TargetConfig) checkCommands() error {
+	for i, v := range []string{c.Build, c.Run, c.Exec} {
+		if v != "" && strings.TrimSpace(v) == "" {
+			return fmt.Errorf("%s is blank", []string{"build", "run", "exec"}[i])
+		}
+	}
+	if c.Debug != "" && c.Run != "" {
+		return fmt.Errorf("debug can not be combined with run, delve runs the built binary")
+	}
+	return nil
+}
+
+// sortTargets orders targets so that every target comes after the
+// targets it depends on, keeping the declared order otherwise.
+func sortTargets(targets []TargetConfig) ([]TargetConfig, error) {
+	var sorted []TargetConfig
+	done := make(map[string]bool)
+
+	for len(sorted) < len(targets) {
+		progress := false
+		for _, t := range targets {
+			if done[t.Name] {
+				continue
+			}
+			ok := true
+			for _, d := range t.DependsOn {
+				if !done[d.Name] {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				done[t.Name] = true
+				sorted = append(sorted, t)
+				progress = true
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, t := range targets {
+				if !done[t.Name] {
+					cycle = append(cycle, t.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// stripComments blanks out the // comments outside of strings, offsets
+// in parse errors stay right.
+func stripComments(data []byte) []byte {
+	out := append([]byte{}, data...)
+	inString, escaped, comment := false, false, false
+	for i, c := range out {
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			} else {
+				out[i] = ' '
+			}
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			comment = true
+			out[i] = ' '
+		}
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package autobuild
+
+import (
+	"crypto/sha1"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHashedFiles bounds the memory of the content cache.
+	maxHashedFiles = 100000
+	// maxHashedSize is the largest file whose content is compared.
+	maxHashedSize = 16 << 20
+)
+
+// contentCache remembers the content hash of the files seen changing.
+// Files found by the initial walk are hashed up front, later files on
+// their first change, which always counts.
+type contentCache struct {
+	sums map[string][sha1.Size]byte
+	lock sync.Mutex
+}
+
+func newContentCache() *contentCache {
+	return &contentCache{sums: make(map[string][sha1.Size]byte)}
+}
+
+// changed reports whether the content of file differs from the last
+// time it was seen, and remembers the current content.
+func (c *contentCache) changed(file string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() || info.Size() > maxHashedSize {
+		delete(c.sums, file)
+		return true
+	}
+
+	sum := hashFile(file)
+	old, ok := c.sums[file]
+	if !ok && len(c.sums) >= maxHashedFiles {
+		for k := range c.sums {
+			delete(c.sums, k)
+			break
+		}
+	}
+	c.sums[file] = sum
+	return !ok || old != sum
+}
+
+// seed remembers the content of a file found while walking a tree, so
+// that saving it unchanged right away is skipped. Unlike changed it
+// stops at maxHashedFiles instead of evicting.
+func (c *contentCache) seed(file string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.sums[file]; ok || len(c.sums) >= maxHashedFiles {
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxHashedSize {
+		return
+	}
+	c.sums[file] = hashFile(file)
+}
+
+// forget drops the hashes of files, their next change counts again.
+func (c *contentCache) forget(files []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, file := range files {
+		delete(c.sums, file)
+	}
+}
+
+// changeBatch collects the changed files until no change arrived for
+// delay, then hands the whole batch to flush.
+type changeBatch struct {
+	delay time.Duration
+	flush func(files []string)
+	files map[string]bool
+	timer *time.Timer
+	lock  sync.Mutex
+}
+
+func newChangeBatch(delay time.Duration, flush func(files []string)) *changeBatch {
+	return &changeBatch{delay: delay, flush: flush, files: make(map[string]bool)}
+}
+
+func (b *changeBatch) add(file string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.files[file] = true
+	if b.timer != nil {
+		b.timer.Reset(b.delay)
+		return
+	}
+	b.timer = time.AfterFunc(b.delay, b.done)
+}
+
+func (b *changeBatch) done() {
+	b.lock.Lock()
+	files := make([]string, 0, len(b.files))
+	for file := range b.files {
+		files = append(files, file)
+	}
+	b.files = make(map[string]bool)
+	b.timer = nil
+	b.lock.Unlock()
+	sort.Strings(files)
+	b.flush(files)
+}
+
+// flushChanges builds the targets a batch of changes belongs to, unless
+// the content of the files did not change.
+func (e *Engine) flushChanges(files []string) {
+	if !e.opts.NoContentHash {
+		changed := files[:0]
+		for _, file := range files {
+			if e.contents.changed(file) {
+				changed = append(changed, file)
+			}
+		}
+		if len(changed) == 0 {
+			e.log.Printf("[INFO] No content changes in %d files, skip build\n", len(files))
+			return
+		}
+		files = changed
+	}
+
+	if !e.syncModules(files) {
+		// nothing was built, saving the same content again must retry
+		e.contents.forget(files)
+		return
+	}
+
+	e.triggerAll(files)
+}
@@ -0,0 +1,35 @@
+package autobuild
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContentSeededByWalk(t *testing.T) {
+	dir := tempProject(t, map[string]string{
+		"go.mod":  "module example.com/app\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	e, err := New(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newPollWatcher(time.Hour, false)
+	defer w.Close()
+	e.registry = newWatchRegistry(w, e.pruneDir, false, e.metrics.watchedDirs, e.log)
+	e.watchTree(dir)
+
+	main := filepath.Join(dir, "main.go")
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	if e.contents.changed(main) {
+		t.Error("unchanged rewrite after the walk counts as a change")
+	}
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() { println() }\n"})
+	if !e.contents.changed(main) {
+		t.Error("changed content does not count as a change")
+	}
+}
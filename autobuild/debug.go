@@ -0,0 +1,42 @@
+package autobuild
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// debugGCFlags disables optimizations and inlining for the debugger.
+var debugGCFlags = []string{"-gcflags", "all=-N -l"}
+
+// checkDebug makes sure delve is installed when a target runs in debug
+// mode.
+func checkDebug(targets []*target) error {
+	for _, t := range targets {
+		if t.cfg.Debug == "" {
+			continue
+		}
+		if _, err := exec.LookPath("dlv"); err != nil {
+			return fmt.Errorf("debug mode needs delve: go install github.com/go-delve/delve/cmd/dlv@latest")
+		}
+	}
+	return nil
+}
+
+// dlvCommand wraps the program in a headless delve server that keeps
+// listening on addr, so an IDE can reconnect after every rebuild.
+func dlvCommand(addr, name string, args []string) (string, []string) {
+	dlvArgs := []string{
+		"exec",
+		"--headless",
+		"--listen=" + addr,
+		"--api-version=2",
+		"--accept-multiclient",
+		"--continue",
+		name,
+	}
+	if len(args) > 0 {
+		dlvArgs = append(dlvArgs, "--")
+		dlvArgs = append(dlvArgs, args...)
+	}
+	return "dlv", dlvArgs
+}
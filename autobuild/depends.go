@@ -0,0 +1,243 @@
+package autobuild
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const defaultReadyTimeout = 30 * time.Second
+
+// targetDep is a resolved dependency of a target.
+type targetDep struct {
+	target    *target
+	condition string
+	cascade   bool
+}
+
+// linkTargets resolves depends_on entries between targets.
+func linkTargets(targets []*target) {
+	byName := make(map[string]*target)
+	for _, t := range targets {
+		byName[t.cfg.Name] = t
+	}
+
+	for _, t := range targets {
+		for _, d := range t.cfg.DependsOn {
+			dep := byName[d.Name]
+			t.dependsOn = append(t.dependsOn, &targetDep{
+				target:    dep,
+				condition: d.Condition,
+				cascade:   d.Cascade,
+			})
+			dep.dependents = append(dep.dependents, t)
+		}
+	}
+}
+
+// waitDeps blocks until every dependency reached its start condition.
+func (t *target) waitDeps() {
+	for _, d := range t.dependsOn {
+		ch := d.target.stateChan(d.condition)
+		select {
+		case <-ch:
+			continue
+		default:
+		}
+		t.log.Printf("[INFO] %sWaiting for %s to be %s\n", t.tag(), d.target.cfg.Name, conditionName(d.condition))
+		<-ch
+	}
+}
+
+// cascadeDependents returns the running targets that must restart
+// along with t.
+func (t *target) cascadeDependents() []*target {
+	var list []*target
+	for _, d := range t.dependents {
+		for _, dep := range d.dependsOn {
+			if dep.target == t && dep.cascade && d.running() {
+				list = append(list, d)
+			}
+		}
+	}
+	return list
+}
+
+func (t *target) stateChan(condition string) chan struct{} {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	if condition == "started" {
+		return t.startedCh
+	}
+	return t.readyCh
+}
+
+// resetState makes waiters block until the next start.
+func (t *target) resetState() {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	if isClosed(t.startedCh) {
+		t.startedCh = make(chan struct{})
+	}
+	if isClosed(t.readyCh) {
+		t.readyCh = make(chan struct{})
+	}
+}
+
+func (t *target) markStarted() {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	if !isClosed(t.startedCh) {
+		close(t.startedCh)
+	}
+}
+
+func (t *target) markReady() {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	if !isClosed(t.readyCh) {
+		close(t.readyCh)
+	}
+}
+
+// probe runs the ready check of a freshly started process and marks
+// the target ready once it passes or times out, which completes rec.
+func (t *target) probe(matcher *logMatcher, cmd *exec.Cmd, exited, stopping chan struct{}, rec *buildRecord) {
+	started := time.Now()
+	check := t.cfg.Ready
+	if check.TCP == "" && check.HTTP == "" && check.Log == "" && check.Delay == "" {
+		t.markReady()
+		t.e.emit(Event{Type: ProcessReady, Target: t.cfg.Name, PID: cmd.Process.Pid})
+		t.e.notify(notifyReady, t.cfg.Name, "Process started")
+		rec.finish("success", 0)
+		return
+	}
+
+	timeout := defaultReadyTimeout
+	if check.Timeout != "" {
+		timeout, _ = time.ParseDuration(check.Timeout)
+	}
+	deadline := time.After(timeout)
+
+	if check.Delay != "" {
+		delay, _ := time.ParseDuration(check.Delay)
+		select {
+		case <-time.After(delay):
+		case <-exited:
+			finishExited(rec, cmd, stopping)
+			return
+		}
+	}
+
+	for {
+		if t.checkReady(matcher) {
+			t.log.Printf("[SUCCESS] %sProcess is ready\n", t.tag())
+			t.markReady()
+			t.e.emit(Event{Type: ProcessReady, Target: t.cfg.Name, PID: cmd.Process.Pid, Duration: time.Since(started)})
+			t.e.notify(notifyReady, t.cfg.Name, fmt.Sprintf("Process ready after %s", round(time.Since(started))))
+			rec.stage("ready", started)
+			rec.finish("success", 0)
+			return
+		}
+
+		select {
+		case <-exited:
+			t.log.Printf("[ERROR] %sProcess exited before being ready\n", t.tag())
+			finishExited(rec, cmd, stopping)
+			return
+		case <-deadline:
+			t.log.Printf("[WARN] %sReady check timed out after %s, starting dependents anyway\n", t.tag(), timeout)
+			t.markReady()
+			rec.stage("ready", started)
+			rec.finish("ready timeout", 0)
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// finishExited completes rec of a process that exited before being
+// ready, one stopped by the engine was only cancelled.
+func finishExited(rec *buildRecord, cmd *exec.Cmd, stopping chan struct{}) {
+	if isClosed(stopping) {
+		rec.finish("cancelled", 0)
+		return
+	}
+	rec.finish("exited", cmd.ProcessState.ExitCode())
+}
+
+func (t *target) checkReady(matcher *logMatcher) bool {
+	check := t.cfg.Ready
+	if check.TCP != "" {
+		conn, err := net.DialTimeout("tcp", check.TCP, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+	if check.HTTP != "" {
+		client := http.Client{Timeout: time.Second}
+		resp, err := client.Get(check.HTTP)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return false
+		}
+	}
+	if matcher != nil && !matcher.seen() {
+		return false
+	}
+	return true
+}
+
+// logMatcher looks for the ready line in the output of a process.
+type logMatcher struct {
+	pattern []byte
+	buf     []byte
+	found   bool
+	lock    sync.Mutex
+}
+
+func (m *logMatcher) Write(b []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.found {
+		return len(b), nil
+	}
+	m.buf = append(m.buf, b...)
+	if bytes.Contains(m.buf, m.pattern) {
+		m.found = true
+		m.buf = nil
+	} else if i := bytes.LastIndexByte(m.buf, '\n'); i >= 0 {
+		m.buf = m.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (m *logMatcher) seen() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.found
+}
+
+func conditionName(condition string) string {
+	if condition == "" {
+		return "ready"
+	}
+	return condition
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+package autobuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listPackage is the subset of `go list -json` output we care about.
+type listPackage struct {
+	Dir            string
+	ImportPath     string
+	Standard       bool
+	GoFiles        []string
+	CgoFiles       []string
+	IgnoredGoFiles []string
+	Imports        []string
+	ImportMap      map[string]string
+}
+
+// depPackage records the files and imports of a package in the closure.
+// Ignored are the files excluded by build constraints.
+type depPackage struct {
+	files   map[string]bool
+	ignored map[string]bool
+	imports map[string]bool
+}
+
+// depGraph is the dependency closure of the package being built. A nil
+// package map means the graph is unknown and every change is relevant.
+type depGraph struct {
+	sync.RWMutex
+	e    *Engine
+	tag  string
+	log  *logger
+	pkg  string
+	pkgs map[string]*depPackage
+}
+
+// refresh reloads the dependency closure with `go list -deps -json`.
+func (g *depGraph) refresh() error {
+	dir, pkg, err := g.e.resolvePkg(g.pkg)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"list", "-e", "-deps", "-json"}
+	if g.e.opts.Mod != "" {
+		args = append(args, "-mod", g.e.opts.Mod)
+	}
+	args = append(args, pkg)
+
+	c := exec.Command("go", args...)
+	c.Dir = dir
+	c.Stderr = g.e.stderr
+	out, err := c.Output()
+	if err != nil {
+		g.forget()
+		return err
+	}
+
+	pkgs := make(map[string]*depPackage)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p listPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			g.forget()
+			return err
+		}
+		if p.Standard || p.Dir == "" {
+			continue
+		}
+
+		dp := &depPackage{
+			files:   make(map[string]bool),
+			ignored: make(map[string]bool),
+			imports: make(map[string]bool),
+		}
+		for _, f := range append(p.GoFiles, p.CgoFiles...) {
+			dp.files[f] = true
+		}
+		for _, f := range p.IgnoredGoFiles {
+			dp.ignored[f] = true
+		}
+		for _, v := range p.Imports {
+			dp.imports[v] = true
+		}
+		for k := range p.ImportMap {
+			dp.imports[k] = true
+		}
+		pkgs[p.Dir] = dp
+	}
+
+	g.Lock()
+	g.pkgs = pkgs
+	g.Unlock()
+	g.log.Printf("[INFO] %sDependency graph loaded, %d packages\n", g.tag, len(pkgs))
+	return nil
+}
+
+// forget drops the graph, every change is relevant until it loads again.
+func (g *depGraph) forget() {
+	g.Lock()
+	g.pkgs = nil
+	g.Unlock()
+}
+
+func (g *depGraph) reload() {
+	if err := g.refresh(); err != nil {
+		g.log.Printf("[WARN] %sFailed to load dependency graph, rebuild on every change: %v\n", g.tag, err)
+	}
+}
+
+// affected reports whether a change to file can affect the built binary.
+func (g *depGraph) affected(file string) bool {
+	switch filepath.Base(file) {
+	case "go.mod", "go.sum", "go.work", "modules.txt":
+		g.reload()
+		return true
+	}
+
+	g.RLock()
+	loaded := g.pkgs != nil
+	pkg := g.pkgs[filepath.Dir(file)]
+	g.RUnlock()
+
+	if !loaded {
+		return true
+	}
+
+	if filepath.Ext(file) != ".go" {
+		// non-go files in directories without go code (templates,
+		// static assets, configs) may still be read by the program.
+		return pkg != nil || !isPackageDir(filepath.Dir(file))
+	}
+
+	if strings.HasSuffix(file, "_test.go") {
+		return false
+	}
+
+	if pkg == nil {
+		return false
+	}
+
+	// a file left out by its build constraints, unless they changed
+	if pkg.ignored[filepath.Base(file)] && !matchFile(file) {
+		return false
+	}
+
+	if g.importsChanged(pkg, file) {
+		g.log.Printf("[INFO] %sImports changed, reloading dependency graph\n", g.tag)
+		g.reload()
+		// a new file may be left out by its build constraints too
+		if g.ignores(file) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignores reports whether go list left file out of its package.
+func (g *depGraph) ignores(file string) bool {
+	g.RLock()
+	defer g.RUnlock()
+	pkg := g.pkgs[filepath.Dir(file)]
+	return pkg != nil && pkg.ignored[filepath.Base(file)]
+}
+
+// affectsTree reports whether a package of the closure lives below dir.
+func (g *depGraph) affectsTree(dir string) bool {
+	g.RLock()
+	defer g.RUnlock()
+
+	if g.pkgs == nil {
+		return true
+	}
+	for pkgDir := range g.pkgs {
+		if underAny(pkgDir, []string{dir}) {
+			return true
+		}
+	}
+	return false
+}
+
+// importsChanged reports whether file was added to or removed from its
+// package or imports something the package did not import before.
+func (g *depGraph) importsChanged(pkg *depPackage, file string) bool {
+	if !pkg.files[filepath.Base(file)] {
+		return true
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path == "C" {
+			continue
+		}
+		if !pkg.imports[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFile reports whether the build constraints of file select it, a
+// file that is gone selects nothing.
+func matchFile(file string) bool {
+	ok, err := build.Default.MatchFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	return ok
+}
+
+func isPackageDir(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	return len(matches) > 0
+}
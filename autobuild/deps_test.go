@@ -0,0 +1,109 @@
+package autobuild
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates files below dir, keyed by their slash separated path.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tempProject writes files into a new temp dir, the caller removes it.
+func tempProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "autobuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// resolve symlinks like /tmp on macOS, go list reports real paths
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, files)
+	return dir
+}
+
+func TestAffectedIgnoredFiles(t *testing.T) {
+	dir := tempProject(t, map[string]string{
+		"go.mod":              "module example.com/app\n",
+		"main.go":             "package main\n\nfunc main() {}\n",
+		"main_plan9.go":       "package main\n\nimport _ \"net/http\"\n",
+		"gen.go":              "//go:build ignore\n\npackage main\n\nimport _ \"os/exec\"\n",
+		"main_test.go":        "package main\n",
+		"internal/x/x.go":     "package x\n",
+		"internal/x/x_bsd.go": "package x\n",
+	})
+	defer os.RemoveAll(dir)
+
+	e, err := New(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := e.targets[0].deps
+	if err := g.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"main.go", true},
+		{"main_plan9.go", false},
+		{"gen.go", false},
+		{"main_test.go", false},
+		{"internal/x/x.go", false}, // not imported
+	}
+	for _, tt := range tests {
+		if got := g.affected(filepath.Join(dir, tt.file)); got != tt.want {
+			t.Errorf("affected(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+
+	// a new file excluded by its build constraints
+	writeFiles(t, dir, map[string]string{"main_windows.go": "package main\n\nimport _ \"net\"\n"})
+	if g.affected(filepath.Join(dir, "main_windows.go")) {
+		t.Error("affected(main_windows.go) = true, want false")
+	}
+
+	// dropping the constraint adds the file to the package
+	writeFiles(t, dir, map[string]string{"gen.go": "package main\n\nimport _ \"os/exec\"\n"})
+	if !g.affected(filepath.Join(dir, "gen.go")) {
+		t.Error("affected(gen.go) without constraint = false, want true")
+	}
+}
+
+func TestCustomBuildWithoutPkg(t *testing.T) {
+	dir := tempProject(t, map[string]string{
+		"go.mod":          "module example.com/app\n",
+		"cmd/api/main.go": "package main\n\nfunc main() {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	e, err := New(Options{Dir: dir, Config: Config{Targets: []TargetConfig{
+		{Name: "api", Build: "go build -o binTmp_api ./cmd/api"},
+		{Name: "web", Pkg: "./cmd/api", Build: "go build -o binTmp_web ./cmd/api"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tg := range e.targets {
+		want := tg.cfg.Name == "web"
+		if got := tg.filterDeps(); got != want {
+			t.Errorf("%s: filterDeps() = %v, want %v", tg.cfg.Name, got, want)
+		}
+	}
+}
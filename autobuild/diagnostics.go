@@ -0,0 +1,46 @@
+package autobuild
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// diagnosticLine matches the file:line:col: message lines of the go
+// command, the column is optional.
+var diagnosticLine = regexp.MustCompile(`^(.+?\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// Diagnostic is a compiler error of a failed build.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// parseDiagnostics extracts the compiler errors from the output of a
+// build run in dir, their files are made absolute. Indented lines
+// continue the message of the previous error.
+func parseDiagnostics(dir, output string) []Diagnostic {
+	var list []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") && len(list) > 0 {
+			list[len(list)-1].Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		m := diagnosticLine.FindStringSubmatch(strings.TrimPrefix(line, "vet: "))
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: m[1], Message: m[4]}
+		if !filepath.IsAbs(d.File) {
+			d.File = filepath.Join(dir, d.File)
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		list = append(list, d)
+	}
+	return list
+}
@@ -0,0 +1,106 @@
+package autobuild
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// CheckStatus is the outcome of a Check.
+type CheckStatus string
+
+// The outcomes of a check.
+const (
+	CheckOK   CheckStatus = "ok"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// Check is the result of one of the checks of Doctor.
+type Check struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+}
+
+// Doctor checks what the engine needs around it: the go toolchain, the
+// workspace, the inotify watch limit and that the addresses the targets
+// listen on are free. addrs are further addresses to check.
+func (e *Engine) Doctor(addrs ...string) []Check {
+	var checks []Check
+
+	if path, err := exec.LookPath("go"); err != nil {
+		checks = append(checks, Check{"go", CheckFail, "go command not found in PATH"})
+	} else {
+		out, err := exec.Command(path, "version").Output()
+		if err != nil {
+			checks = append(checks, Check{"go", CheckFail, fmt.Sprintf("go version -> %v", err)})
+		} else {
+			checks = append(checks, Check{"go", CheckOK, strings.TrimSpace(string(out))})
+		}
+	}
+
+	e.findLocalModules()
+	if err := e.checkWorkspace(); err != nil {
+		checks = append(checks, Check{"workspace", CheckFail, err.Error()})
+	} else if file, _ := e.work.get(); file != "" {
+		checks = append(checks, Check{"workspace", CheckOK, file})
+	}
+
+	checks = append(checks, e.checkWatches())
+
+	for _, t := range e.targets {
+		if t.cfg.Ready.TCP != "" {
+			checks = append(checks, checkAddr(t.tag()+"ready.tcp", t.cfg.Ready.TCP))
+		}
+		if t.cfg.Ready.HTTP != "" {
+			if u, err := url.Parse(t.cfg.Ready.HTTP); err == nil && u.Host != "" {
+				port := u.Port()
+				if port == "" {
+					port = "80"
+					if u.Scheme == "https" {
+						port = "443"
+					}
+				}
+				checks = append(checks, checkAddr(t.tag()+"ready.http", net.JoinHostPort(u.Hostname(), port)))
+			}
+		}
+		if t.cfg.Debug != "" {
+			checks = append(checks, checkAddr(t.tag()+"debug", t.cfg.Debug))
+		}
+	}
+	for _, addr := range addrs {
+		checks = append(checks, checkAddr("listen", addr))
+	}
+	return checks
+}
+
+// checkWatches compares the directories to watch with the inotify limit.
+func (e *Engine) checkWatches() Check {
+	need := e.countWatches(e.watchRoots())
+	if e.opts.Watcher == "poll" {
+		return Check{"watches", CheckOK, fmt.Sprintf("%d directories, polled", need)}
+	}
+	limit, ok := watchLimit()
+	if !ok {
+		return Check{"watches", CheckOK, fmt.Sprintf("%d directories", need)}
+	}
+	if need > limit {
+		return Check{"watches", CheckWarn, fmt.Sprintf("%d directories but fs.inotify.max_user_watches is %d, "+
+			"raise it with: sudo sysctl -w fs.inotify.max_user_watches=%d", need, limit, recommendedWatches(need, limit))}
+	}
+	return Check{"watches", CheckOK, fmt.Sprintf("%d directories, fs.inotify.max_user_watches is %d", need, limit)}
+}
+
+// checkAddr reports whether addr can be listened on, a conflict usually
+// means another instance is still running.
+func checkAddr(name, addr string) Check {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return Check{name, CheckWarn, fmt.Sprintf("%s is in use, is another instance or an old process still running?", addr)}
+	}
+	l.Close()
+	return Check{name, CheckOK, addr + " is free"}
+}
@@ -0,0 +1,105 @@
+package autobuild
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// saveDelay is how long the events of a file are collected before they
+// are reported as a single change. Atomic saves write a temp file,
+// rename the original away and rename the temp file in its place.
+const saveDelay = 100 * time.Millisecond
+
+// isEditorTemp reports whether file is a temp, swap or backup file
+// written by an editor while saving.
+func isEditorTemp(file string) bool {
+	base := filepath.Base(file)
+
+	// vim probes if a directory is writable with a file named 4913, it
+	// is usually gone by the time the event is handled
+	if base == "4913" {
+		info, err := os.Lstat(file)
+		return err != nil || info.Mode().IsRegular()
+	}
+
+	switch filepath.Ext(base) {
+	case ".swp", ".swo", ".swn", ".swx", ".kate-swp":
+		return true
+	}
+
+	switch {
+	case strings.HasSuffix(base, "~"): // vim, emacs, gedit backups
+		return true
+	case strings.HasPrefix(base, ".#"): // emacs lock files
+		return true
+	case strings.HasPrefix(base, "#") && strings.HasSuffix(base, "#"): // emacs auto saves
+		return true
+	case strings.Contains(base, "___jb_tmp___"), strings.Contains(base, "___jb_old___"): // jetbrains safe write
+		return true
+	case strings.HasPrefix(base, ".goutputstream-"): // gedit
+		return true
+	}
+	return false
+}
+
+// saveCoalescer merges the events of one file within saveDelay, so that
+// a save produces exactly one change no matter how the editor wrote it.
+type saveCoalescer struct {
+	pending map[string]*pendingSave
+	flush   func(op, file string)
+	lock    sync.Mutex
+}
+
+type pendingSave struct {
+	created bool // the file did not exist before the first event
+	timer   *time.Timer
+}
+
+func newSaveCoalescer(flush func(op, file string)) *saveCoalescer {
+	return &saveCoalescer{
+		pending: make(map[string]*pendingSave),
+		flush:   flush,
+	}
+}
+
+func (c *saveCoalescer) add(op, file string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if p, ok := c.pending[file]; ok {
+		p.timer.Reset(saveDelay)
+		return
+	}
+
+	c.pending[file] = &pendingSave{
+		created: op == "Create",
+		timer: time.AfterFunc(saveDelay, func() {
+			c.done(file)
+		}),
+	}
+}
+
+// done reports the net change: a file that exists now was created or
+// written, one that is gone was removed, unless it only lived during
+// the collected events.
+func (c *saveCoalescer) done(file string) {
+	c.lock.Lock()
+	p := c.pending[file]
+	delete(c.pending, file)
+	c.lock.Unlock()
+
+	_, err := os.Stat(file)
+	exists := err == nil
+
+	switch {
+	case exists && p.created:
+		c.flush("Create", file)
+	case exists:
+		c.flush("Write", file)
+	case !p.created:
+		c.flush("Remove", file)
+	}
+}
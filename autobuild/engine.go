@@ -0,0 +1,392 @@
+// Package autobuild watches a Go project, rebuilds its programs when
+// their sources change and supervises the running processes. The
+// goautobuild command is a thin wrapper around it.
+package autobuild
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// intervalTime is the minimum time between two builds of a target.
+	intervalTime = 3 * time.Second
+	// stopTimeout is how long a process gets to exit after SIGINT.
+	stopTimeout = 5 * time.Second
+	// appName prefixes the binaries built, they never trigger a build.
+	appName = "binTmp"
+)
+
+// Options configures an Engine. The zero value builds and runs the
+// package in the working directory whenever a file below it changes.
+type Options struct {
+	// Dir is the project directory, relative paths are resolved
+	// against it. Defaults to the working directory.
+	Dir string
+	// Config holds the targets and the settings shared by them. Without
+	// targets the package in Dir is built and run.
+	Config Config
+
+	// WatchDirs are watched besides Dir, Ignore are never watched.
+	WatchDirs []string
+	Ignore    []string
+	// Mod is passed to the go command as -mod.
+	Mod string
+
+	// Watcher is the file watcher backend: "auto" (default),
+	// "fsnotify" or "poll".
+	Watcher string
+	// PollInterval is the scan interval of the poll backend, 1s by
+	// default. With PollHash set it compares contents too.
+	PollInterval time.Duration
+	PollHash     bool
+	// BatchDelay is how long changes are collected into a single
+	// build, 300ms by default.
+	BatchDelay time.Duration
+	// NoContentHash builds even if the content of the changed files is
+	// the same as before.
+	NoContentHash bool
+	// FollowSymlinks watches the directories symlinks point to.
+	FollowSymlinks bool
+	// AllChanges builds a target on every change, not only on changes
+	// to the packages it imports.
+	AllChanges bool
+
+	// Stdout and Stderr receive the output of the go command, hooks
+	// and processes, os.Stdout and os.Stderr by default.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Output returns the writer receiving the build and process output
+	// of a target instead of Stdout and Stderr.
+	Output func(target string) io.Writer
+
+	// Log returns the logger of the messages of a target, "" is the
+	// engine. Without it, or if it returns nil, the standard logger
+	// is used.
+	Log func(target string) *log.Logger
+
+	// OnEvent is called for every event, it must not block.
+	OnEvent func(Event)
+}
+
+// Engine watches the project, builds the targets and runs them.
+type Engine struct {
+	opts      Options
+	dir       string
+	watchDirs []string
+	ignore    []string
+	stdout    io.Writer
+	stderr    io.Writer
+	targets   []*target
+	modSync   []string
+	coverRoot string
+
+	watcher   watcher
+	registry  *watchRegistry
+	saves     *saveCoalescer
+	batch     *changeBatch
+	contents  *contentCache
+	modules   localModules
+	work      workspace
+	log       *logger
+	hooks     hookState
+	history   buildHistory
+	profiles  *profileSet
+	metrics   *metrics
+	notifiers *notifiers
+	subs      subscribers
+	status    statusBoard
+
+	running bool
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+// New validates opts and creates the targets, nothing is built or run
+// before Run.
+func New(opts Options) (*Engine, error) {
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	if opts.Watcher == "" {
+		opts.Watcher = "auto"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchDelay <= 0 {
+		opts.BatchDelay = 300 * time.Millisecond
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+
+	dir, err := filepath.Abs(filepath.Clean(opts.Dir))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		opts:      opts,
+		dir:       dir,
+		stdout:    opts.Stdout,
+		stderr:    opts.Stderr,
+		contents:  newContentCache(),
+		metrics:   newMetrics(),
+		coverRoot: filepath.Join(os.TempDir(), fmt.Sprintf("goautobuild-cover-%d", os.Getpid())),
+		log:       newLogger(opts, ""),
+		done:      make(chan struct{}),
+	}
+	e.work.log = e.log
+	e.saves = newSaveCoalescer(e.fileChanged)
+	e.batch = newChangeBatch(opts.BatchDelay, e.flushChanges)
+
+	for _, v := range opts.WatchDirs {
+		p, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		e.watchDirs = append(e.watchDirs, p)
+	}
+	for _, v := range opts.Ignore {
+		p, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		e.ignore = append(e.ignore, p)
+	}
+
+	cfg := opts.Config
+	if len(cfg.Targets) == 0 {
+		cfg.Targets = []TargetConfig{{}}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	e.modSync = cfg.ModSync
+
+	e.profiles, err = newProfileSet(cfg.Profiles, cfg.Profile)
+	if err != nil {
+		return nil, err
+	}
+	e.notifiers = newNotifiers(cfg.Notify, e.stderr)
+
+	for _, v := range cfg.Targets {
+		t, err := newTarget(e, v)
+		if err != nil {
+			return nil, err
+		}
+		e.targets = append(e.targets, t)
+	}
+	linkTargets(e.targets)
+	for _, t := range e.targets {
+		if t.cfg.Exec != "" {
+			e.status.markExec(t.cfg.Name)
+		}
+	}
+
+	if err := checkDebug(e.targets); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Run watches the project and builds and runs the targets until ctx is
+// done, then it stops the processes. An engine runs only once.
+func (e *Engine) Run(ctx context.Context) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+
+	name, _ := e.Profile()
+	e.log.Printf("[INFO] Build profile %s\n", name)
+
+	w, err := newWatcher(e.opts.Watcher, e.opts.PollInterval, e.opts.PollHash, e.log)
+	if err != nil {
+		return fmt.Errorf("watcher -> %v", err)
+	}
+	e.watcher = w
+	e.registry = newWatchRegistry(w, e.pruneDir, e.opts.FollowSymlinks, e.metrics.watchedDirs, e.log)
+	defer e.stop()
+
+	go func() {
+		for {
+			select {
+			case event := <-w.Events():
+				e.handleEvent(event)
+
+			case err := <-w.Errors():
+				e.log.Println("[ERROR] watcher error:", err)
+
+			case <-e.done:
+				return
+			}
+		}
+	}()
+
+	watchDir := e.watchRoots()
+
+	if e.opts.Watcher != "poll" {
+		e.checkWatchLimit(watchDir)
+	}
+
+	walkStart := time.Now()
+	watched := 0
+	for _, v := range watchDir {
+		e.log.Println("[INFO] watch", v)
+		watched += e.watchTree(v)
+	}
+	e.log.Printf("[INFO] Watching %d directories, walk took %s\n", watched, time.Since(walkStart))
+
+	for _, t := range e.targets {
+		for file := range t.envFiles {
+			e.addFileWatch(file)
+		}
+		for file := range t.buildEnvFiles {
+			e.addFileWatch(file)
+		}
+	}
+
+	e.reloadLocalModules()
+	if err := e.checkWorkspace(); err != nil {
+		return err
+	}
+
+	for _, t := range e.targets {
+		if t.filterDeps() {
+			t.deps.reload()
+		}
+		go t.autobuild()
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// Build runs the build pipeline of every target once, exec targets run
+// their command, without watching or starting anything. It returns an
+// error naming the targets that failed.
+func (e *Engine) Build(ctx context.Context) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+
+	e.findLocalModules()
+	if err := e.checkWorkspace(); err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, t := range e.targets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var ok bool
+		if t.cfg.Exec != "" {
+			ok = t.runExec(nil)
+		} else {
+			rec := t.newRecord(nil)
+			if ok = t.build(rec); ok {
+				rec.finish("success", 0)
+			}
+		}
+		if !ok {
+			failed = append(failed, TargetLabel(t.cfg.Name))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("build failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// begin marks the engine started, an engine runs only once.
+func (e *Engine) begin() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.running {
+		return fmt.Errorf("engine already started")
+	}
+	e.running = true
+	return nil
+}
+
+// TargetLabel is how a target is called in messages, the target without
+// a name is main.
+func TargetLabel(name string) string {
+	if name == "" {
+		return "main"
+	}
+	return name
+}
+
+// watchRoots are the directories watched with their subdirectories.
+func (e *Engine) watchRoots() []string {
+	var roots []string
+	roots = append(roots, e.dir)
+	roots = append(roots, e.watchDirs...)
+	for _, t := range e.targets {
+		roots = append(roots, t.roots[1:]...)
+	}
+	return roots
+}
+
+// stop ends the processes, dependents before the targets they depend
+// on, and merges coverage data.
+func (e *Engine) stop() {
+	e.lock.Lock()
+	close(e.done)
+	e.lock.Unlock()
+
+	for i := len(e.targets) - 1; i >= 0; i-- {
+		e.targets[i].kill()
+	}
+	e.mergeCoverage()
+	e.watcher.Close()
+}
+
+// stopped reports whether Run has returned, no process may start then.
+func (e *Engine) stopped() bool {
+	return isClosed(e.done)
+}
+
+// Rebuild builds and restarts every target regardless of the build
+// interval.
+func (e *Engine) Rebuild() {
+	for _, t := range e.targets {
+		go t.rebuild()
+	}
+}
+
+// watchTree watches dir and the directories below it and hashes the
+// files found, unless content hashing is off.
+func (e *Engine) watchTree(dir string) int {
+	if e.opts.NoContentHash {
+		return e.registry.addTree(dir, nil)
+	}
+	return e.registry.addTree(dir, e.contents.seed)
+}
+
+// addFileWatch watches the directory of a single file, editors often
+// replace files so watching the file itself is not enough.
+func (e *Engine) addFileWatch(file string) {
+	e.registry.addDir(filepath.Dir(file))
+}
+
+// absPath resolves p relative to the project directory.
+func (e *Engine) absPath(p string) (string, error) {
+	return absPath(e.dir, p)
+}
@@ -0,0 +1,231 @@
+package autobuild
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvConfig is the environment of a build or run step. Unset removes
+// inherited variables ("*" removes all of them), then Files are loaded
+// and Vars applied in order. Values may refer to ${VAR}.
+type EnvConfig struct {
+	Vars  []string `json:"vars"`
+	Files []string `json:"files"`
+	Unset []string `json:"unset"`
+}
+
+// UnmarshalJSON accepts a plain list of KEY=VALUE as a shorthand for
+// {"vars": [...]}.
+func (e *EnvConfig) UnmarshalJSON(data []byte) error {
+	var vars []string
+	if err := json.Unmarshal(data, &vars); err == nil {
+		*e = EnvConfig{Vars: vars}
+		return nil
+	}
+
+	type plain EnvConfig
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func (e *EnvConfig) validate() error {
+	for _, v := range e.Vars {
+		if strings.Index(v, "=") <= 0 {
+			return fmt.Errorf("invalid env %q, want KEY=VALUE", v)
+		}
+	}
+	return nil
+}
+
+// environ builds the environment of a step on top of base, relative
+// env files are found in dir.
+func (e *EnvConfig) environ(dir string, base []string) ([]string, error) {
+	env := newEnvList()
+	unsetAll := false
+	unset := make(map[string]bool)
+	for _, k := range e.Unset {
+		if k == "*" {
+			unsetAll = true
+		}
+		unset[k] = true
+	}
+	if !unsetAll {
+		for _, kv := range base {
+			k, v := splitEnv(kv)
+			if !unset[k] {
+				env.set(k, v)
+			}
+		}
+	}
+
+	for _, file := range e.Files {
+		path, err := absPath(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range e.Vars {
+		k, v := splitEnv(kv)
+		env.set(k, os.Expand(v, env.get))
+	}
+	return env.list(), nil
+}
+
+// envList is an ordered set of environment variables.
+type envList struct {
+	keys   []string
+	values map[string]string
+}
+
+func newEnvList() *envList {
+	return &envList{values: make(map[string]string)}
+}
+
+func (l *envList) set(k, v string) {
+	if _, ok := l.values[k]; !ok {
+		l.keys = append(l.keys, k)
+	}
+	l.values[k] = v
+}
+
+func (l *envList) get(k string) string {
+	if v, ok := l.values[k]; ok {
+		return v
+	}
+	return os.Getenv(k)
+}
+
+func (l *envList) list() []string {
+	env := make([]string, 0, len(l.keys))
+	for _, k := range l.keys {
+		env = append(env, k+"="+l.values[k])
+	}
+	return env
+}
+
+// load reads a .env file: KEY=VALUE lines with optional `export`,
+// comments and single or double quoted values. A comment may follow a
+// value after " #". Unquoted and double quoted values are expanded.
+func (l *envList) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("%s:%d: invalid line, want KEY=VALUE", path, n)
+		}
+		k := strings.TrimSpace(line[:i])
+		v := strings.TrimSpace(line[i+1:])
+
+		if len(v) > 0 && (v[0] == '\'' || v[0] == '"') {
+			end := closingQuote(v)
+			if end < 0 {
+				return fmt.Errorf("%s:%d: unterminated quote", path, n)
+			}
+			// anything after the closing quote is a comment
+			quote := v[0]
+			v = v[1:end]
+			if quote == '"' {
+				v = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(v)
+				v = os.Expand(v, l.get)
+			}
+		} else {
+			if j := strings.Index(v, " #"); j >= 0 {
+				v = strings.TrimSpace(v[:j])
+			}
+			v = os.Expand(v, l.get)
+		}
+		l.set(k, v)
+	}
+	return scanner.Err()
+}
+
+// closingQuote returns the index of the quote closing v, or -1. Double
+// quoted values may escape quotes with a backslash.
+func closingQuote(v string) int {
+	for i := 1; i < len(v); i++ {
+		switch {
+		case v[0] == '"' && v[i] == '\\':
+			i++
+		case v[i] == v[0]:
+			return i
+		}
+	}
+	return -1
+}
+
+// reloadEnv restarts the process after one of its env files changed.
+// Changes within intervalTime of the last reload restart it once more
+// when the interval has passed.
+func (t *target) reloadEnv() {
+	t.lock.Lock()
+	if wait := intervalTime - time.Since(t.envTime); wait > 0 {
+		if t.envTimer == nil {
+			t.log.Printf("[INFO] %sEnv file changed less than %s ago, restart again in %s\n", t.tag(), intervalTime, round(wait))
+			t.envTimer = time.AfterFunc(wait, func() {
+				t.lock.Lock()
+				t.envTimer = nil
+				t.lock.Unlock()
+				if !t.e.stopped() {
+					t.reloadEnv()
+				}
+			})
+		}
+		t.lock.Unlock()
+		return
+	}
+	t.envTime = time.Now()
+	t.lock.Unlock()
+
+	t.log.Printf("[INFO] %sEnv file changed, restarting\n", t.tag())
+	t.restart(nil)
+}
+
+// envFileChanged handles a change to an env file of any target: run env
+// files restart the process, build env files trigger a rebuild.
+func (e *Engine) envFileChanged(file string) bool {
+	found := false
+	for _, t := range e.targets {
+		if t.envFiles[file] {
+			found = true
+			go t.reloadEnv()
+		}
+		if t.buildEnvFiles[file] {
+			found = true
+			go t.autobuild(file)
+		}
+	}
+	return found
+}
+
+func splitEnv(kv string) (string, string) {
+	// windows has per drive variables like "=C:=C:\"
+	i := strings.Index(kv, "=")
+	if i == 0 {
+		i = strings.Index(kv[1:], "=") + 1
+	}
+	if i <= 0 {
+		return kv, ""
+	}
+	return kv[:i], kv[i+1:]
+}
@@ -0,0 +1,53 @@
+package autobuild
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvLoad(t *testing.T) {
+	dir := tempProject(t, map[string]string{".env": `# comment
+export A=1
+B = plain value # comment
+C="quoted" # comment
+D='single # not a comment' # comment
+E="say \"hi\"\n${A}" # comment
+F='${A}'
+G=a#b
+H="has # inside"
+`})
+	defer os.RemoveAll(dir)
+
+	env := newEnvList()
+	if err := env.load(filepath.Join(dir, ".env")); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"A": "1",
+		"B": "plain value",
+		"C": "quoted",
+		"D": "single # not a comment",
+		"E": "say \"hi\"\n1",
+		"F": "${A}",
+		"G": "a#b",
+		"H": "has # inside",
+	}
+	for k, v := range want {
+		if got := env.values[k]; got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if len(env.keys) != len(want) {
+		t.Errorf("keys = %v, want %d keys", env.keys, len(want))
+	}
+}
+
+func TestEnvLoadUnterminatedQuote(t *testing.T) {
+	dir := tempProject(t, map[string]string{".env": "A=\"open\n"})
+	defer os.RemoveAll(dir)
+
+	if err := newEnvList().load(filepath.Join(dir, ".env")); err == nil {
+		t.Error("want an error for an unterminated quote")
+	}
+}
@@ -0,0 +1,113 @@
+package autobuild
+
+import (
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// handleEvent keeps the watched directories in sync with the tree and
+// triggers the targets a change belongs to. Ops are bit masks and may be
+// combined, so every op is checked on its own.
+func (e *Engine) handleEvent(event fsnotify.Event) {
+	e.metrics.observeEvent(event)
+
+	if event.Op&(fsnotify.Write|fsnotify.Create) != 0 && e.envFileChanged(event.Name) {
+		return
+	}
+
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 && e.isModuleFile(event.Name) {
+		go func() {
+			e.reloadLocalModules()
+			if err := e.checkWorkspace(); err != nil {
+				e.log.Println("[ERROR]", err)
+			}
+		}()
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && e.registry.watched(event.Name) {
+		// a directory was deleted or moved away, its watches are gone
+		n := e.registry.removeTree(event.Name)
+		e.log.Printf("[INFO] Remove directory: %s, %d directories unwatched\n", event.Name, n)
+		e.dirRemoved(event.Name)
+		return
+	}
+
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if e.pruneDir(event.Name) {
+				return
+			}
+			// a directory created or moved in may already hold files
+			e.log.Println("[INFO] Create directory: ", event.Name)
+			n := e.registry.addTree(event.Name, func(file string) {
+				e.saves.add("Create", file)
+			})
+			e.log.Printf("[INFO] %d directories watched below %s\n", n, event.Name)
+			return
+		}
+	}
+
+	if isEditorTemp(event.Name) {
+		return
+	}
+
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		e.saves.add("Create", event.Name)
+	case event.Op&fsnotify.Write == fsnotify.Write:
+		e.saves.add("Write", event.Name)
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		e.saves.add("Remove", event.Name)
+	}
+}
+
+// fileChanged receives the coalesced change of a file.
+func (e *Engine) fileChanged(op, file string) {
+	if isBinary(file) || isEditorTemp(file) || !e.matchAny(file) {
+		return
+	}
+	if e.hookOutput(file) {
+		e.log.Println("[INFO] Ignore generated file: ", file)
+		return
+	}
+	e.log.Printf("[INFO] %s: %s\n", op, file)
+	e.emit(Event{Type: FileChanged, Files: []string{file}, Message: op})
+	e.batch.add(file)
+}
+
+func (e *Engine) matchAny(file string) bool {
+	for _, t := range e.targets {
+		if t.match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerAll rebuilds every target the changed files belong to.
+func (e *Engine) triggerAll(files []string) {
+	for _, t := range e.targets {
+		var matched []string
+		for _, file := range files {
+			if t.match(file) {
+				matched = append(matched, file)
+			}
+		}
+		if len(matched) > 0 {
+			go t.trigger(matched)
+		}
+	}
+}
+
+// dirRemoved rebuilds the targets that imported a package below dir.
+func (e *Engine) dirRemoved(dir string) {
+	if e.hookOutput(dir) {
+		return
+	}
+	for _, t := range e.targets {
+		if t.watches(dir) && !underAny(dir, t.ignore) {
+			go t.triggerTree(dir)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package autobuild
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// shell returns the command running line in the system shell.
+func shell(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
+}
+
+// shellExec runs the program of line through the shell with args appended.
+// The shell replaces itself with the program, so signals reach it.
+func shellExec(line string, args []string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", append([]string{"/C", line}, args...)...)
+	}
+	return exec.Command("sh", append([]string{"-c", "exec " + line + ` "$@"`, "sh"}, args...)...)
+}
+
+// runExec runs the exec command of the target once for files and
+// reports whether it succeeded. A run still in progress is cancelled
+// first, its result is dropped.
+func (t *target) runExec(files []string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer close(done)
+
+	t.procLock.Lock()
+	prevCancel, prevDone := t.execCancel, t.execDone
+	t.execCancel, t.execDone = cancel, done
+	t.procLock.Unlock()
+
+	if prevCancel != nil {
+		prevCancel()
+		<-prevDone
+	}
+	// a newer run took over while the previous one was stopping
+	if ctx.Err() != nil || t.e.stopped() {
+		return false
+	}
+
+	rec := t.newRecord(files)
+	_, prof := t.e.currentProfile()
+	env, err := t.cfg.Env.environ(t.e.dir, append(os.Environ(), prof.Env...))
+	if err != nil {
+		t.log.Printf("[ERROR] %sRun env -> %v\n", t.tag(), err)
+		t.fail(rec, "env error", -1, nil)
+		return false
+	}
+
+	var output bytes.Buffer
+	cmd := shell(t.cfg.Exec)
+	cmd.Dir = t.e.dir
+	cmd.Env = env
+	cmd.Stdout = io.MultiWriter(t.stdout, &output)
+	cmd.Stderr = io.MultiWriter(t.stderr, &output)
+	setProcessGroup(cmd)
+
+	t.log.Printf("[INFO] %sRunning %s\n", t.tag(), t.cfg.Exec)
+	t.e.emit(Event{Type: BuildStarted, Target: t.cfg.Name, Files: files})
+	stage := time.Now()
+	if err := cmd.Start(); err != nil {
+		t.log.Printf("[ERROR] %sStart command -> %v\n", t.tag(), err)
+		t.fail(rec, "start failed", -1, nil)
+		return false
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-waitErr
+		t.log.Printf("[INFO] %sCommand cancelled\n", t.tag())
+		rec.stage("exec", stage)
+		rec.finish("cancelled", 0)
+		return false
+	}
+	rec.stage("exec", stage)
+
+	if err != nil {
+		t.log.Printf("[ERROR] %sCommand failed (%v) in %s\n", t.tag(), err, round(time.Since(stage)))
+		t.fail(rec, "failed", exitCode(err), parseDiagnostics(t.e.dir, output.String()))
+		return false
+	}
+	t.log.Printf("[SUCCESS] %sCommand succeeded in %s\n", t.tag(), round(time.Since(stage)))
+	t.e.emit(Event{Type: BuildSucceeded, Target: t.cfg.Name, Files: files, Duration: time.Since(rec.Start)})
+	rec.finish("success", 0)
+	return true
+}
+
+// stopExec cancels the exec command in progress and waits for it.
+func (t *target) stopExec() {
+	t.procLock.Lock()
+	cancel, done := t.execCancel, t.execDone
+	t.procLock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// checkExec rejects settings that do not apply to an exec target.
+func (c TargetConfig) checkExec() error {
+	if c.Exec == "" {
+		return nil
+	}
+	if c.Build != "" || c.Run != "" || c.Debug != "" || len(c.Args) > 0 {
+		return fmt.Errorf("exec can not be combined with build, run, args or debug")
+	}
+	if len(c.DependsOn) > 0 {
+		return fmt.Errorf("exec targets can not depend on other targets")
+	}
+	if len(c.Hooks.all()) > 0 {
+		return fmt.Errorf("exec targets can not have hooks, put the commands into exec")
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package autobuild
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// localModules are the module directories outside the watch tree that
+// the build uses: local replace targets of go.mod and the modules of
+// go.work. They are watched automatically.
+type localModules struct {
+	sync.Mutex
+	dirs  []string
+	files map[string]bool // go.mod and go.work in use
+	roots []string        // where a new go.work would be picked up
+}
+
+// modFile is the subset of `go mod edit -json` output we need.
+type modFile struct {
+	Replace []struct {
+		New struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+// workFile is the subset of `go work edit -json` output we need.
+type workFile struct {
+	Use []struct {
+		DiskPath string
+	}
+}
+
+// goEnv returns the values of go environment variables in dir.
+func goEnv(dir string, keys ...string) ([]string, error) {
+	c := exec.Command("go", append([]string{"env"}, keys...)...)
+	c.Dir = dir
+	out, err := c.Output()
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for len(values) < len(keys) {
+		values = append(values, "")
+	}
+	return values, nil
+}
+
+// findLocalModules reads go.mod and go.work and returns the local module
+// directories and the module files that were read.
+func (e *Engine) findLocalModules() ([]string, []string) {
+	env, err := goEnv(e.dir, "GOMOD", "GOWORK")
+	if err != nil {
+		e.log.Println("[WARN] go env ->", err)
+		return nil, nil
+	}
+	gomod, gowork := env[0], env[1]
+
+	var dirs, files []string
+	if gomod != "" && gomod != os.DevNull {
+		files = append(files, gomod)
+		var mf modFile
+		if err := editJSON(e.dir, "mod", gomod, &mf); err != nil {
+			e.log.Printf("[WARN] Failed to read %s -> %v\n", gomod, err)
+		}
+		for _, r := range mf.Replace {
+			if r.New.Version != "" || !isLocalPath(r.New.Path) {
+				continue
+			}
+			dirs = append(dirs, resolveDir(filepath.Dir(gomod), r.New.Path))
+		}
+	}
+
+	var modules []string
+	if gowork == "off" {
+		gowork = ""
+	}
+	if gowork != "" {
+		files = append(files, gowork)
+		var wf workFile
+		if err := editJSON(e.dir, "work", gowork, &wf); err != nil {
+			e.log.Printf("[WARN] Failed to read %s -> %v\n", gowork, err)
+		}
+		for _, u := range wf.Use {
+			modules = append(modules, resolveDir(filepath.Dir(gowork), u.DiskPath))
+		}
+		dirs = append(dirs, modules...)
+	}
+	e.work.set(gowork, modules)
+	return dirs, files
+}
+
+func editJSON(dir, kind, file string, v interface{}) error {
+	c := exec.Command("go", kind, "edit", "-json", file)
+	c.Dir = dir
+	out, err := c.Output()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+func isLocalPath(p string) bool {
+	return filepath.IsAbs(p) || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") ||
+		strings.HasPrefix(p, `.\`) || strings.HasPrefix(p, `..\`)
+}
+
+func resolveDir(base, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(base, p)
+	}
+	return filepath.Clean(p)
+}
+
+// reloadLocalModules watches the local modules currently referenced by
+// go.mod and go.work and forgets the ones that are no longer used.
+func (e *Engine) reloadLocalModules() {
+	dirs, files := e.findLocalModules()
+
+	m := &e.modules
+	m.Lock()
+	old := m.dirs
+	m.dirs = nil
+	m.files = make(map[string]bool)
+	m.roots = []string{e.dir}
+	for _, f := range files {
+		m.files[f] = true
+		if !contains(m.roots, filepath.Dir(f)) {
+			m.roots = append(m.roots, filepath.Dir(f))
+		}
+	}
+	for _, dir := range dirs {
+		if !e.inWatchTree(dir) {
+			m.dirs = append(m.dirs, dir)
+		}
+	}
+	cur := m.dirs
+	m.Unlock()
+
+	for _, f := range files {
+		if !e.inWatchTree(f) {
+			e.addFileWatch(f)
+		}
+	}
+
+	for _, dir := range cur {
+		if !contains(old, dir) {
+			n := e.watchTree(dir)
+			e.log.Printf("[INFO] Watch local module %s, %d directories\n", dir, n)
+		}
+	}
+	for _, dir := range old {
+		if !contains(cur, dir) {
+			e.registry.removeTree(dir)
+			e.log.Printf("[INFO] Unwatch local module %s\n", dir)
+		}
+	}
+}
+
+// inLocalModule reports whether file belongs to a local module.
+func (e *Engine) inLocalModule(file string) bool {
+	e.modules.Lock()
+	defer e.modules.Unlock()
+	return underAny(file, e.modules.dirs)
+}
+
+// isModuleFile reports whether file is the go.mod or go.work in use, or
+// a go.work next to the project or one of them: it switches the build to
+// workspace mode once created.
+func (e *Engine) isModuleFile(file string) bool {
+	e.modules.Lock()
+	defer e.modules.Unlock()
+	if e.modules.files[file] {
+		return true
+	}
+	switch filepath.Base(file) {
+	case "go.work", "go.work.sum":
+		return contains(e.modules.roots, filepath.Dir(file))
+	}
+	return false
+}
+
+// inWatchTree reports whether path is watched through the project
+// directory or the extra watch directories.
+func (e *Engine) inWatchTree(path string) bool {
+	for _, t := range e.targets {
+		if underAny(path, t.roots) {
+			return true
+		}
+	}
+	return underAny(path, e.watchDirs)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package autobuild
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHistory bounds the number of build records kept in memory.
+const maxHistory = 200
+
+// stageNames are the pipeline stages in order, the CSV export has a
+// column for each of them.
+var stageNames = []string{"pre-build", "compile", "exec", "post-build", "stop", "start", "ready"}
+
+// BuildRecord is one build and restart cycle of a target.
+type BuildRecord struct {
+	Seq      int
+	Target   string
+	Profile  string
+	Files    []string
+	Start    time.Time
+	Stages   []StageTiming
+	Result   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// StageTiming is the duration of one stage of a build cycle.
+type StageTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// buildRecord is a record still being filled in.
+type buildRecord struct {
+	e   *Engine
+	log *logger
+	BuildRecord
+
+	done bool
+	lock sync.Mutex
+}
+
+// buildHistory keeps the last maxHistory records.
+type buildHistory struct {
+	sync.Mutex
+	records []BuildRecord
+	seq     int
+}
+
+func (t *target) newRecord(files []string) *buildRecord {
+	name, _ := t.e.currentProfile()
+	return &buildRecord{
+		e:   t.e,
+		log: t.log,
+		BuildRecord: BuildRecord{
+			Target:  t.cfg.Name,
+			Profile: name,
+			Files:   files,
+			Start:   time.Now(),
+		},
+	}
+}
+
+// stage records that a stage started at since has finished. Methods of
+// buildRecord accept a nil record, restarts not caused by a build have
+// none.
+func (r *buildRecord) stage(name string, since time.Time) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Stages = append(r.Stages, StageTiming{Name: name, Duration: time.Since(since)})
+}
+
+// finish completes the record once, adds it to the history and logs a
+// one-line summary.
+func (r *buildRecord) finish(result string, exitCode int) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	if r.done {
+		r.lock.Unlock()
+		return
+	}
+	r.done = true
+	r.Result = result
+	r.ExitCode = exitCode
+	r.Duration = time.Since(r.Start)
+	rec := r.BuildRecord
+	r.lock.Unlock()
+
+	e := r.e
+	e.history.Lock()
+	e.history.seq++
+	rec.Seq = e.history.seq
+	e.history.records = append(e.history.records, rec)
+	if len(e.history.records) > maxHistory {
+		e.history.records = e.history.records[len(e.history.records)-maxHistory:]
+	}
+	e.history.Unlock()
+
+	e.metrics.observeBuild(rec)
+	e.notifyBuild(rec)
+
+	level := "[INFO]"
+	if result != "success" {
+		level = "[ERROR]"
+	}
+	r.log.Printf("%s %s\n", level, rec.summary(e.dir))
+}
+
+// summary is a one line description of the record, files are shown
+// relative to dir.
+func (r BuildRecord) summary(dir string) string {
+	var b strings.Builder
+	if r.Target != "" {
+		fmt.Fprintf(&b, "[%s] ", r.Target)
+	}
+	fmt.Fprintf(&b, "Build #%d %s in %s", r.Seq, r.Result, round(r.Duration))
+	if r.ExitCode != 0 {
+		fmt.Fprintf(&b, " (exit %d)", r.ExitCode)
+	}
+
+	var stages []string
+	for _, s := range r.Stages {
+		stages = append(stages, s.Name+" "+round(s.Duration).String())
+	}
+	if len(stages) > 0 {
+		b.WriteString(": " + strings.Join(stages, ", "))
+	}
+
+	if len(r.Files) > 0 {
+		var files []string
+		for i, f := range r.Files {
+			if i == 3 {
+				files = append(files, "...")
+				break
+			}
+			files = append(files, relPath(dir, f))
+		}
+		fmt.Fprintf(&b, " [%d files: %s]", len(r.Files), strings.Join(files, ", "))
+	}
+	return b.String()
+}
+
+func round(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(10 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func relPath(dir, file string) string {
+	if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// exitCode returns the exit code of a failed command, -1 if it did not
+// run.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	return -1
+}
+
+// History returns the recorded builds, oldest first.
+func (e *Engine) History() []BuildRecord {
+	e.history.Lock()
+	defer e.history.Unlock()
+	return append([]BuildRecord{}, e.history.records...)
+}
+
+// PrintHistory logs the summaries of the last n builds.
+func (e *Engine) PrintHistory(n int) {
+	records := e.History()
+	if len(records) == 0 {
+		e.log.Println("[INFO] No builds yet")
+		return
+	}
+	for i := len(records) - n; i < len(records); i++ {
+		if i >= 0 {
+			e.log.Printf("[INFO] %s\n", records[i].summary(e.dir))
+		}
+	}
+}
+
+// ExportHistory writes the build history to path, as CSV if it ends in
+// .csv and as JSON otherwise.
+func (e *Engine) ExportHistory(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = WriteHistoryCSV(f, e.History())
+	} else {
+		err = WriteHistoryJSON(f, e.History())
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type recordJSON struct {
+	Seq        int                `json:"seq"`
+	Target     string             `json:"target,omitempty"`
+	Profile    string             `json:"profile"`
+	Files      []string           `json:"files,omitempty"`
+	Start      time.Time          `json:"start"`
+	Result     string             `json:"result"`
+	ExitCode   int                `json:"exit_code"`
+	DurationMs float64            `json:"duration_ms"`
+	StagesMs   map[string]float64 `json:"stages_ms"`
+}
+
+// WriteHistoryJSON writes records as a JSON array, durations in
+// milliseconds.
+func WriteHistoryJSON(w io.Writer, records []BuildRecord) error {
+	list := make([]recordJSON, 0, len(records))
+	for _, r := range records {
+		v := recordJSON{
+			Seq:        r.Seq,
+			Target:     r.Target,
+			Profile:    r.Profile,
+			Files:      r.Files,
+			Start:      r.Start,
+			Result:     r.Result,
+			ExitCode:   r.ExitCode,
+			DurationMs: ms(r.Duration),
+			StagesMs:   make(map[string]float64),
+		}
+		for _, s := range r.Stages {
+			v.StagesMs[s.Name] = ms(s.Duration)
+		}
+		list = append(list, v)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+// WriteHistoryCSV writes records as CSV with a column per stage.
+func WriteHistoryCSV(w io.Writer, records []BuildRecord) error {
+	cw := csv.NewWriter(w)
+	header := []string{"seq", "target", "profile", "start", "result", "exit_code", "duration_ms", "files"}
+	for _, name := range stageNames {
+		header = append(header, name+"_ms")
+	}
+	cw.Write(header)
+
+	for _, r := range records {
+		row := []string{
+			strconv.Itoa(r.Seq),
+			r.Target,
+			r.Profile,
+			r.Start.Format(time.RFC3339),
+			r.Result,
+			strconv.Itoa(r.ExitCode),
+			formatMs(r.Duration),
+			strings.Join(r.Files, " "),
+		}
+		for _, name := range stageNames {
+			v := ""
+			for _, s := range r.Stages {
+				if s.Name == name {
+					v = formatMs(s.Duration)
+				}
+			}
+			row = append(row, v)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(ms(d), 'f', 1, 64)
+}
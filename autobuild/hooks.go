@@ -0,0 +1,200 @@
+package autobuild
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Hooks are commands run around the build and run steps of a target.
+type Hooks struct {
+	PreBuild  []Hook `json:"pre_build"`
+	PostBuild []Hook `json:"post_build"`
+	PreStart  []Hook `json:"pre_start"`
+	PostStop  []Hook `json:"post_stop"`
+}
+
+// Hook is a shell command. Outputs are glob patterns of the files it
+// generates, changes to them never trigger a rebuild. With Fail set an
+// error aborts the rest of the pipeline.
+type Hook struct {
+	Cmd     string   `json:"cmd"`
+	Dir     string   `json:"dir"`
+	Timeout string   `json:"timeout"`
+	Fail    bool     `json:"fail"`
+	Outputs []string `json:"outputs"`
+}
+
+// UnmarshalJSON accepts a plain string as a shorthand for {"cmd": "..."}.
+func (h *Hook) UnmarshalJSON(data []byte) error {
+	var cmd string
+	if err := json.Unmarshal(data, &cmd); err == nil {
+		*h = Hook{Cmd: cmd}
+		return nil
+	}
+
+	type plain Hook
+	return json.Unmarshal(data, (*plain)(h))
+}
+
+func (h *Hooks) all() []Hook {
+	var list []Hook
+	list = append(list, h.PreBuild...)
+	list = append(list, h.PostBuild...)
+	list = append(list, h.PreStart...)
+	return append(list, h.PostStop...)
+}
+
+func (h *Hooks) validate() error {
+	for _, v := range h.all() {
+		if v.Cmd == "" {
+			return fmt.Errorf("hook without cmd")
+		}
+		if v.Timeout != "" {
+			if _, err := time.ParseDuration(v.Timeout); err != nil {
+				return fmt.Errorf("hook %q: %v", v.Cmd, err)
+			}
+		}
+		for _, p := range v.Outputs {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("hook %q: %v", v.Cmd, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hookState knows the files written by hooks and module syncs, so that
+// they are not mistaken for user changes: the outputs declared by the
+// hooks and the files a sync rewrote.
+type hookState struct {
+	sync.Mutex
+	outputs   []string
+	generated map[string]time.Time // file -> mod time it was written with
+}
+
+func (s *hookState) register(h Hooks) {
+	s.Lock()
+	defer s.Unlock()
+	for _, v := range h.all() {
+		s.outputs = append(s.outputs, v.Outputs...)
+	}
+}
+
+// hookOutput reports whether a change to file was made by a hook.
+func (e *Engine) hookOutput(file string) bool {
+	s := &e.hooks
+	s.Lock()
+	defer s.Unlock()
+
+	if mtime, ok := s.generated[file]; ok {
+		if info, err := os.Stat(file); err == nil && info.ModTime().Equal(mtime) {
+			return true
+		}
+		delete(s.generated, file)
+	}
+
+	rel, err := filepath.Rel(e.dir, file)
+	if err != nil {
+		rel = file
+	}
+	for _, p := range s.outputs {
+		if ok, _ := filepath.Match(p, filepath.Base(file)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, filepath.ToSlash(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite runs fn, which may rewrite files. The ones whose mod time it
+// changed are not treated as user changes until they are written again.
+func (s *hookState) rewrite(files []string, fn func()) {
+	before := make(map[string]time.Time)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			before[file] = info.ModTime()
+		}
+	}
+
+	fn()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.generated == nil {
+		s.generated = make(map[string]time.Time)
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if mtime, ok := before[file]; !ok || !mtime.Equal(info.ModTime()) {
+			s.generated[file] = info.ModTime()
+		}
+	}
+}
+
+// runHooks runs the hooks of a stage in order. It returns an error when a
+// hook with Fail set did not succeed.
+func (t *target) runHooks(stage string, list []Hook, env []string) error {
+	for _, h := range list {
+		err := t.runHook(stage, h, env)
+		if err == nil {
+			continue
+		}
+		if h.Fail {
+			t.log.Printf("[ERROR] %s%s hook %q failed -> %v\n", t.tag(), stage, h.Cmd, err)
+			return err
+		}
+		t.log.Printf("[WARN] %s%s hook %q failed -> %v\n", t.tag(), stage, h.Cmd, err)
+	}
+	return nil
+}
+
+// runHook runs h in a process group of its own, on timeout the whole
+// group is killed: processes started by the shell would keep its output
+// open and Wait would never return.
+func (t *target) runHook(stage string, h Hook, env []string) error {
+	cmd := shell(h.Cmd)
+	cmd.Dir = t.e.dir
+	if h.Dir != "" {
+		dir, err := t.e.absPath(h.Dir)
+		if err != nil {
+			return err
+		}
+		cmd.Dir = dir
+	}
+	cmd.Env = env
+	cmd.Stdout = t.stdout
+	cmd.Stderr = t.stderr
+	setProcessGroup(cmd)
+
+	t.log.Printf("[INFO] %sRun %s hook: %s\n", t.tag(), stage, h.Cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+	if h.Timeout == "" {
+		return <-waitErr
+	}
+
+	timeout, _ := time.ParseDuration(h.Timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-waitErr:
+		return err
+	case <-timer.C:
+		killProcessGroup(cmd)
+		<-waitErr
+		return fmt.Errorf("timeout after %s", h.Timeout)
+	}
+}
@@ -0,0 +1,104 @@
+package autobuild
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// prunedDirs are never watched, they are large and never hold sources.
+var prunedDirs = map[string]bool{
+	".git":         true,
+	".hg":          true,
+	".svn":         true,
+	".idea":        true,
+	".vscode":      true,
+	"node_modules": true,
+}
+
+var limitHelpOnce sync.Once
+
+// pruneDir reports whether the directory at path is left unwatched.
+func (e *Engine) pruneDir(path string) bool {
+	if prunedDirs[filepath.Base(path)] {
+		return true
+	}
+	for _, v := range e.ignore {
+		if v == path {
+			return true
+		}
+	}
+
+	// only prune what every target ignores
+	if len(e.targets) == 0 {
+		return false
+	}
+	for _, t := range e.targets {
+		if !underAny(path, t.ignore) {
+			return false
+		}
+	}
+	return true
+}
+
+// countWatches returns how many directories addTree registers for roots.
+func (e *Engine) countWatches(roots []string) int {
+	n := 0
+	for _, root := range roots {
+		walk(root, e.opts.FollowSymlinks, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if e.pruneDir(path) {
+				return filepath.SkipDir
+			}
+			n++
+			return nil
+		})
+	}
+	return n
+}
+
+// checkWatchLimit compares the number of watches needed with the kernel
+// limit and explains how to raise it.
+func (e *Engine) checkWatchLimit(roots []string) {
+	need := e.countWatches(roots)
+	limit, ok := watchLimit()
+	if !ok {
+		e.log.Printf("[INFO] %d directories to watch\n", need)
+		return
+	}
+
+	e.log.Printf("[INFO] %d directories to watch, fs.inotify.max_user_watches is %d\n", need, limit)
+	if need > limit {
+		if e.opts.Watcher == "fsnotify" {
+			e.log.Printf("[WARN] Not enough inotify watches, the remaining directories are not watched\n")
+		} else {
+			e.log.Printf("[WARN] Not enough inotify watches, the remaining directories are polled\n")
+		}
+		printLimitHelp(e.log, need)
+	}
+}
+
+// printLimitHelp explains how to raise the inotify watch limit, once.
+func printLimitHelp(l *logger, need int) {
+	limitHelpOnce.Do(func() {
+		limit, ok := watchLimit()
+		if !ok {
+			return
+		}
+		want := recommendedWatches(need, limit)
+		l.Printf("[INFO] Raise the limit with: sudo sysctl -w fs.inotify.max_user_watches=%d\n", want)
+		l.Printf("[INFO] To keep it after reboot: echo fs.inotify.max_user_watches=%d | sudo tee -a /etc/sysctl.conf\n", want)
+	})
+}
+
+// recommendedWatches is the inotify watch limit to suggest: a power of
+// two from 524288 up, above the current limit and what is needed.
+func recommendedWatches(need, limit int) int {
+	want := 524288
+	for want <= limit || want < need {
+		want *= 2
+	}
+	return want
+}
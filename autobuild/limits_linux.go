@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package autobuild
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// watchLimit returns fs.inotify.max_user_watches.
+func watchLimit() (int, bool) {
+	data, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
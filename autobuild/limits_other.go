@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package autobuild
+
+// watchLimit is only known on linux.
+func watchLimit() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,38 @@
+package autobuild
+
+import (
+	"fmt"
+	"log"
+)
+
+// logger writes the log messages of the engine or of a target to the
+// logger from the Log option, or to the standard logger. A nil logger
+// uses the standard logger too.
+type logger struct {
+	l *log.Logger
+}
+
+// newLogger returns the logger of target, "" is the engine.
+func newLogger(opts Options, target string) *logger {
+	if opts.Log == nil {
+		return &logger{}
+	}
+	return &logger{l: opts.Log(target)}
+}
+
+func (l *logger) Printf(format string, v ...interface{}) {
+	l.output(fmt.Sprintf(format, v...))
+}
+
+func (l *logger) Println(v ...interface{}) {
+	l.output(fmt.Sprintln(v...))
+}
+
+func (l *logger) output(s string) {
+	// 3 reports the caller of Printf with log.Lshortfile
+	if l == nil || l.l == nil {
+		log.Output(3, s)
+		return
+	}
+	l.l.Output(3, s)
+}
@@ -0,0 +1,37 @@
+package autobuild
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogOption(t *testing.T) {
+	logs := make(map[string]*bytes.Buffer)
+	e, err := New(Options{
+		Config: Config{Targets: []TargetConfig{{Name: "a"}, {Name: "ab"}}},
+		Log: func(target string) *log.Logger {
+			logs[target] = &bytes.Buffer{}
+			return log.New(logs[target], "", 0)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Pause("a", true); err != nil {
+		t.Fatal(err)
+	}
+	e.PrintHistory(1)
+
+	if got := logs["a"].String(); got != "[INFO] [a] Paused\n" {
+		t.Errorf("log of a = %q", got)
+	}
+	if got := logs["ab"].String(); got != "" {
+		t.Errorf("log of ab = %q, want nothing", got)
+	}
+	if got := logs[""].String(); !strings.Contains(got, "No builds yet") {
+		t.Errorf("log of the engine = %q", got)
+	}
+}
@@ -0,0 +1,209 @@
+package autobuild
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// durationBuckets are the upper bounds in seconds of duration histograms.
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics are the metrics of the build, process and watcher subsystems
+// of an engine, served in the Prometheus text format.
+type metrics struct {
+	buildsTotal    *metric
+	buildDuration  *histogram
+	stageDuration  *histogram
+	processStarts  *metric
+	processCrashes *metric
+	processUp      *metric
+	watcherEvents  *metric
+	watchedDirs    *metric
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		buildsTotal: newMetric("goautobuild_builds_total", "counter",
+			"Builds by target and result.", "target", "result"),
+		buildDuration: newHistogram("goautobuild_build_duration_seconds",
+			"Duration of build and restart cycles.", "target"),
+		stageDuration: newHistogram("goautobuild_stage_duration_seconds",
+			"Duration of the stages of build and restart cycles.", "target", "stage"),
+		processStarts: newMetric("goautobuild_process_starts_total", "counter",
+			"Processes started.", "target"),
+		processCrashes: newMetric("goautobuild_process_crashes_total", "counter",
+			"Processes that exited without being stopped.", "target"),
+		processUp: newMetric("goautobuild_process_up", "gauge",
+			"Whether the process of the target is running.", "target"),
+		watcherEvents: newMetric("goautobuild_watcher_events_total", "counter",
+			"File system events by operation.", "op"),
+		watchedDirs: newMetric("goautobuild_watched_directories", "gauge",
+			"Directories currently watched."),
+	}
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.buildsTotal.write(w)
+	m.buildDuration.write(w)
+	m.stageDuration.write(w)
+	m.processStarts.write(w)
+	m.processCrashes.write(w)
+	m.processUp.write(w)
+	m.watcherEvents.write(w)
+	m.watchedDirs.write(w)
+}
+
+// metric is a counter or gauge with a value per label combination.
+type metric struct {
+	name, kind, help string
+	labels           []string
+	values           map[string]float64
+	lock             sync.Mutex
+}
+
+func newMetric(name, kind, help string, labels ...string) *metric {
+	return &metric{name: name, kind: kind, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (m *metric) add(v float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[labelString(m.labels, labels)] += v
+}
+
+func (m *metric) set(v float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[labelString(m.labels, labels)] = v
+}
+
+func (m *metric) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, key, formatFloat(m.values[key]))
+	}
+}
+
+// histogram counts observations into durationBuckets.
+type histogram struct {
+	name, help string
+	labels     []string
+	series     map[string]*histogramSeries
+	lock       sync.Mutex
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, labels ...string) *histogram {
+	return &histogram{name: name, help: help, labels: labels, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogram) observe(v float64, labels ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := strings.Join(labels, "\x00")
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{labels: labels, counts: make([]uint64, len(durationBuckets))}
+		h.series[key] = s
+	}
+	for i, le := range durationBuckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		names := append(append([]string{}, h.labels...), "le")
+		for i, le := range durationBuckets {
+			values := append(append([]string{}, s.labels...), formatFloat(le))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, s.labels), s.count)
+	}
+}
+
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsHandler serves the metrics of the engine in the Prometheus
+// text format.
+func (e *Engine) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		e.metrics.write(w)
+	})
+}
+
+// observeBuild updates the build metrics from a finished record.
+func (m *metrics) observeBuild(r BuildRecord) {
+	m.buildsTotal.add(1, r.Target, r.Result)
+	m.buildDuration.observe(r.Duration.Seconds(), r.Target)
+	for _, s := range r.Stages {
+		m.stageDuration.observe(s.Duration.Seconds(), r.Target, s.Name)
+	}
+}
+
+// observeEvent counts every operation set in event.
+func (m *metrics) observeEvent(event fsnotify.Event) {
+	for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod} {
+		if event.Op&op != 0 {
+			m.watcherEvents.add(1, strings.ToLower(op.String()))
+		}
+	}
+}
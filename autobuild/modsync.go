@@ -0,0 +1,131 @@
+package autobuild
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// moduleErrors are fragments of go command errors about module
+// resolution, as opposed to compile errors.
+var moduleErrors = []string{
+	"missing go.sum entry",
+	"no required module provides package",
+	"is replaced but not required",
+	"no matching versions",
+	"cannot find module providing package",
+	"inconsistent vendoring",
+	"updates to go.mod needed",
+	"go.mod file not found",
+	"module lookup disabled",
+	"unknown revision",
+	"verifying module",
+	"invalid version",
+	"reading go.work",
+	"errors parsing go.mod",
+}
+
+func checkModSync(steps []string) error {
+	for _, step := range steps {
+		switch step {
+		case "tidy", "download", "vendor":
+		default:
+			return fmt.Errorf("unknown mod sync step %q, want tidy, download or vendor", step)
+		}
+	}
+	return nil
+}
+
+// isModuleChange reports whether a change to file changes the dependencies.
+func isModuleChange(file string) bool {
+	switch filepath.Base(file) {
+	case "go.mod", "go.sum", "go.work", "go.work.sum":
+		return true
+	case "modules.txt":
+		return filepath.Base(filepath.Dir(file)) == "vendor"
+	}
+	return false
+}
+
+// moduleRoot returns the directory of the module a module file belongs to.
+func moduleRoot(file string) string {
+	if filepath.Base(file) == "modules.txt" {
+		return filepath.Dir(filepath.Dir(file))
+	}
+	return filepath.Dir(file)
+}
+
+// syncModules runs the mod sync steps in every module whose module files
+// changed. It returns false if a step failed, the build would only fail
+// with the same error.
+func (e *Engine) syncModules(files []string) bool {
+	if len(e.modSync) == 0 {
+		return true
+	}
+
+	var dirs []string
+	for _, file := range files {
+		if isModuleChange(file) && !contains(dirs, moduleRoot(file)) {
+			dirs = append(dirs, moduleRoot(file))
+		}
+	}
+
+	ok := true
+	for _, dir := range dirs {
+		e.hooks.rewrite(syncedFiles(dir), func() {
+			if err := e.runModSync(dir); err != nil {
+				e.log.Printf("[ERROR] ================Module sync failed================= %v\n", err)
+				ok = false
+			}
+		})
+	}
+	return ok
+}
+
+// syncedFiles are the module files of the module in dir that the mod
+// sync steps may rewrite.
+func syncedFiles(dir string) []string {
+	return []string{
+		filepath.Join(dir, "go.mod"),
+		filepath.Join(dir, "go.sum"),
+		filepath.Join(dir, "vendor", "modules.txt"),
+	}
+}
+
+// runModSync runs the mod sync steps, the go mod commands configured to
+// run after module files changed: tidy, download and vendor.
+func (e *Engine) runModSync(dir string) error {
+	work, _ := e.work.get()
+
+	for _, step := range e.modSync {
+		args := []string{"mod", step}
+		cmdDir := dir
+		if step == "vendor" && work != "" {
+			// go mod vendor is not supported in workspace mode
+			args = []string{"work", "vendor"}
+			cmdDir = filepath.Dir(work)
+		}
+
+		cmd := exec.Command("go", args...)
+		cmd.Dir = cmdDir
+		cmd.Stdout = e.stdout
+		cmd.Stderr = e.stderr
+		e.log.Printf("[INFO] Run go %s in %s\n", strings.Join(args, " "), cmdDir)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go %s -> %v", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+// isModuleError reports whether build output is a module resolution
+// error rather than a compile error.
+func isModuleError(output string) bool {
+	for _, v := range moduleErrors {
+		if strings.Contains(output, v) {
+			return true
+		}
+	}
+	return false
+}
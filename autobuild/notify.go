@@ -0,0 +1,227 @@
+package autobuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The events notifiers are triggered on.
+const (
+	notifyFailed    = "failed"
+	notifyRecovered = "recovered"
+	notifyCrashed   = "crashed"
+	notifyReady     = "ready"
+)
+
+// defaultNotifyEvents leaves out ready, it happens on every restart.
+var defaultNotifyEvents = []string{notifyFailed, notifyRecovered, notifyCrashed}
+
+// NotifyConfig selects the notifiers and the events they report.
+type NotifyConfig struct {
+	Desktop  bool     `json:"desktop"`
+	Terminal bool     `json:"terminal"`
+	Webhook  string   `json:"webhook"`
+	Events   []string `json:"events"`
+}
+
+func (c *NotifyConfig) validate() error {
+	for _, e := range c.Events {
+		switch e {
+		case notifyFailed, notifyRecovered, notifyCrashed, notifyReady:
+		default:
+			return fmt.Errorf("unknown notify event %q, want failed, recovered, crashed or ready", e)
+		}
+	}
+	if c.Webhook != "" && !strings.HasPrefix(c.Webhook, "http://") && !strings.HasPrefix(c.Webhook, "https://") {
+		return fmt.Errorf("invalid webhook %q, want an http or https URL", c.Webhook)
+	}
+	return nil
+}
+
+// notification is what notifiers report, the webhook posts it as JSON.
+type notification struct {
+	Event   string    `json:"event"`
+	Target  string    `json:"target,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func (n notification) title() string {
+	if n.Target == "" {
+		return "goautobuild: " + n.Event
+	}
+	return "goautobuild: " + n.Target + " " + n.Event
+}
+
+type notifier interface {
+	notify(n notification) error
+}
+
+// notifiers are the notifiers of an engine and the build state they
+// report changes of.
+type notifiers struct {
+	sync.Mutex
+	list   []notifier
+	events map[string]bool
+	failed map[string]bool
+}
+
+// newNotifiers creates the notifiers selected in c, the terminal
+// notifier writes to w.
+func newNotifiers(c NotifyConfig, w io.Writer) *notifiers {
+	n := &notifiers{
+		events: make(map[string]bool),
+		failed: make(map[string]bool),
+	}
+
+	if c.Desktop {
+		n.list = append(n.list, desktopNotifier{})
+	}
+	if c.Terminal {
+		n.list = append(n.list, terminalNotifier{w: w})
+	}
+	if c.Webhook != "" {
+		n.list = append(n.list, &webhookNotifier{
+			url:     c.Webhook,
+			client:  &http.Client{Timeout: 5 * time.Second},
+			retries: 2,
+			backoff: time.Second,
+		})
+	}
+
+	events := c.Events
+	if len(events) == 0 {
+		events = defaultNotifyEvents
+	}
+	for _, e := range events {
+		n.events[e] = true
+	}
+	return n
+}
+
+// notify hands an event to every notifier without blocking.
+func (e *Engine) notify(event, target, message string) {
+	e.notifiers.Lock()
+	list := e.notifiers.list
+	enabled := e.notifiers.events[event]
+	e.notifiers.Unlock()
+
+	if !enabled {
+		return
+	}
+	n := notification{Event: event, Target: target, Message: message, Time: time.Now()}
+	for _, v := range list {
+		go func(v notifier) {
+			if err := v.notify(n); err != nil {
+				e.log.Printf("[WARN] Notify %s -> %v\n", event, err)
+			}
+		}(v)
+	}
+}
+
+// notifyBuild reports failed builds and the first success after them.
+func (e *Engine) notifyBuild(r BuildRecord) {
+	// a cancelled build says nothing about the code
+	if r.Result == "cancelled" {
+		return
+	}
+	failed := r.Result != "success" && r.Result != "ready timeout"
+
+	e.notifiers.Lock()
+	wasFailed := e.notifiers.failed[r.Target]
+	e.notifiers.failed[r.Target] = failed
+	e.notifiers.Unlock()
+
+	switch {
+	case r.Result == "exited":
+		// the process crashed before being ready, notified as crashed
+	case failed:
+		e.notify(notifyFailed, r.Target, fmt.Sprintf("Build %s", r.Result))
+	case wasFailed:
+		e.notify(notifyRecovered, r.Target, fmt.Sprintf("Build succeeded in %s", round(r.Duration)))
+	}
+}
+
+// desktopNotifier shows a desktop notification, with notify-send over
+// D-Bus on Linux and osascript on macOS.
+type desktopNotifier struct{}
+
+func (desktopNotifier) notify(n notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		urgency := "normal"
+		if n.Event == notifyFailed || n.Event == notifyCrashed {
+			urgency = "critical"
+		}
+		cmd = exec.Command("notify-send", "-a", "goautobuild", "-u", urgency, n.title(), n.Message)
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", n.Message, n.title())
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// terminalNotifier rings the bell and sends an OSC 9 escape, which
+// terminals like iTerm2, kitty and Windows Terminal show as a
+// notification. Others ignore it.
+type terminalNotifier struct {
+	w io.Writer
+}
+
+func (t terminalNotifier) notify(n notification) error {
+	_, err := fmt.Fprintf(t.w, "\a\x1b]9;%s: %s\a", n.title(), n.Message)
+	return err
+}
+
+// webhookNotifier posts the notification as JSON. Failed requests and
+// server errors are retried, the wait doubling after every attempt.
+type webhookNotifier struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func (w *webhookNotifier) notify(n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	wait := w.backoff
+	for i := 0; ; i++ {
+		retry, err := w.post(body)
+		if err == nil || !retry || i >= w.retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post sends body once, retry tells whether a failure may be temporary.
+func (w *webhookNotifier) post(body []byte) (bool, error) {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
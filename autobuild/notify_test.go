@@ -0,0 +1,135 @@
+package autobuild
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhook(url string) *webhookNotifier {
+	return &webhookNotifier{
+		url:     url,
+		client:  &http.Client{Timeout: 200 * time.Millisecond},
+		retries: 2,
+		backoff: 10 * time.Millisecond,
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	got := make(chan notification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		got <- n
+	}))
+	defer srv.Close()
+
+	sent := notification{Event: notifyFailed, Target: "api", Message: "Build failed", Time: time.Now()}
+	if err := newTestWebhook(srv.URL).notify(sent); err != nil {
+		t.Fatal(err)
+	}
+
+	n := <-got
+	if n.Event != sent.Event || n.Target != sent.Target || n.Message != sent.Message || !n.Time.Equal(sent.Time) {
+		t.Errorf("payload = %+v, want %+v", n, sent)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int32
+		ok       bool
+	}{
+		{"server error then success", []int{500, 503, 200}, 3, true},
+		{"server error every time", []int{500, 500, 500, 500}, 3, false},
+		{"client error", []int{400, 200}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&attempts, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			err := newTestWebhook(srv.URL).notify(notification{Event: notifyCrashed})
+			if (err == nil) != tt.ok {
+				t.Errorf("err = %v, want ok %v", err, tt.ok)
+			}
+			if n := atomic.LoadInt32(&attempts); n != tt.attempts {
+				t.Errorf("%d attempts, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	var attempts int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	err := newTestWebhook(srv.URL).notify(notification{Event: notifyFailed})
+	if err == nil {
+		t.Fatal("want a timeout error")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+	// 3 timeouts of 200ms and backoffs of 10ms and 20ms
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("notify took %s", d)
+	}
+}
+
+type recordNotifier chan notification
+
+func (r recordNotifier) notify(n notification) error {
+	r <- n
+	return nil
+}
+
+func TestNotifyExitedBeforeReady(t *testing.T) {
+	rec := make(recordNotifier, 4)
+	e := &Engine{notifiers: newNotifiers(NotifyConfig{}, nil)}
+	e.notifiers.list = []notifier{rec}
+
+	// a crash before being ready is reported once, as crashed
+	e.notify(notifyCrashed, "api", "Process exited unexpectedly")
+	e.notifyBuild(BuildRecord{Target: "api", Result: "exited"})
+	if n := <-rec; n.Event != notifyCrashed {
+		t.Errorf("event = %q, want %q", n.Event, notifyCrashed)
+	}
+
+	// the next successful build is a recovery
+	e.notifyBuild(BuildRecord{Target: "api", Result: "success"})
+	if n := <-rec; n.Event != notifyRecovered {
+		t.Errorf("event = %q, want %q", n.Event, notifyRecovered)
+	}
+
+	select {
+	case n := <-rec:
+		t.Errorf("unexpected notification %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,224 @@
+package autobuild
+
+import (
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollWatcher finds changes by comparing stat results, and optionally
+// content hashes, of the watched paths at a fixed interval. It works on
+// file systems that do not deliver inotify events such as NFS, docker
+// bind mounts and VM shared folders.
+type pollWatcher struct {
+	interval time.Duration
+	hash     bool
+	paths    map[string]map[string]fileState
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	lock     sync.Mutex
+}
+
+// fileState is what a poll remembers about a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	sum     [sha1.Size]byte
+}
+
+func newPollWatcher(interval time.Duration, hash bool) *pollWatcher {
+	p := &pollWatcher{
+		interval: interval,
+		hash:     hash,
+		paths:    make(map[string]map[string]fileState),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *pollWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	state, err := p.scan(path)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return &os.PathError{Op: "watch", Path: path, Err: os.ErrNotExist}
+	}
+
+	p.lock.Lock()
+	p.paths[path] = state
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *pollWatcher) Remove(path string) error {
+	p.lock.Lock()
+	delete(p.paths, filepath.Clean(path))
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *pollWatcher) Events() <-chan fsnotify.Event { return p.events }
+func (p *pollWatcher) Errors() <-chan error          { return p.errors }
+
+func (p *pollWatcher) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *pollWatcher) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+func (p *pollWatcher) poll() {
+	p.lock.Lock()
+	paths := make([]string, 0, len(p.paths))
+	for path := range p.paths {
+		paths = append(paths, path)
+	}
+	p.lock.Unlock()
+
+	for _, path := range paths {
+		state, err := p.scan(path)
+		if err != nil && !os.IsNotExist(err) {
+			p.send(nil, err)
+			continue
+		}
+
+		p.lock.Lock()
+		old, ok := p.paths[path]
+		if ok {
+			if state == nil {
+				delete(p.paths, path)
+			} else {
+				p.paths[path] = state
+			}
+		}
+		p.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if state == nil {
+			p.send(&fsnotify.Event{Name: path, Op: fsnotify.Remove}, nil)
+			continue
+		}
+		p.diff(old, state)
+	}
+}
+
+func (p *pollWatcher) diff(old, cur map[string]fileState) {
+	for name, s := range cur {
+		o, ok := old[name]
+		if !ok {
+			p.send(&fsnotify.Event{Name: name, Op: fsnotify.Create}, nil)
+			continue
+		}
+		if o.mode != s.mode {
+			p.send(&fsnotify.Event{Name: name, Op: fsnotify.Chmod}, nil)
+		}
+		if !s.mode.IsDir() && (!o.modTime.Equal(s.modTime) || o.size != s.size || o.sum != s.sum) {
+			p.send(&fsnotify.Event{Name: name, Op: fsnotify.Write}, nil)
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			p.send(&fsnotify.Event{Name: name, Op: fsnotify.Remove}, nil)
+		}
+	}
+}
+
+func (p *pollWatcher) send(event *fsnotify.Event, err error) {
+	if event != nil {
+		select {
+		case p.events <- *event:
+		case <-p.done:
+		}
+		return
+	}
+	select {
+	case p.errors <- err:
+	case <-p.done:
+	}
+}
+
+// scan returns the state of path and, for a directory, of its entries.
+// A path that does not exist yields a nil state.
+func (p *pollWatcher) scan(path string) (map[string]fileState, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := map[string]fileState{}
+	if !info.IsDir() {
+		state[path] = p.stat(path, info)
+		return state, nil
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		name := filepath.Join(path, fi.Name())
+		state[name] = p.stat(name, fi)
+	}
+	return state, nil
+}
+
+func (p *pollWatcher) stat(path string, info os.FileInfo) fileState {
+	s := fileState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		mode:    info.Mode(),
+	}
+	if p.hash && info.Mode().IsRegular() {
+		s.sum = hashFile(path)
+	}
+	return s
+}
+
+func hashFile(path string) [sha1.Size]byte {
+	var sum [sha1.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	io.Copy(h, f)
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package autobuild
+
+import "os/exec"
+
+// setProcessGroup does nothing on windows, only the shell is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
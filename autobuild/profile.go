@@ -0,0 +1,168 @@
+package autobuild
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coverOutput is the merged coverage profile written on exit.
+const coverOutput = "coverage.out"
+
+// Profile is a named set of build flags and build environment. With
+// Cover set every run writes coverage data into its own GOCOVERDIR.
+type Profile struct {
+	Flags []string `json:"flags"`
+	Env   []string `json:"env"`
+	Cover bool     `json:"cover"`
+}
+
+// builtinProfiles are always available, config files may replace them.
+var builtinProfiles = map[string]Profile{
+	"dev":     {Env: []string{"GOGC=off"}},
+	"race":    {Flags: []string{"-race"}, Env: []string{"GOGC=off"}},
+	"cover":   {Flags: []string{"-cover"}, Env: []string{"GOGC=off"}, Cover: true},
+	"release": {Flags: []string{"-trimpath", "-ldflags", "-s -w"}},
+}
+
+// profileSet is the active profile and the profiles to choose from.
+type profileSet struct {
+	sync.Mutex
+	name string
+	list map[string]Profile
+}
+
+// newProfileSet adds the profiles of the config to the builtin ones and
+// activates name, dev if empty.
+func newProfileSet(list map[string]Profile, name string) (*profileSet, error) {
+	p := &profileSet{name: "dev", list: make(map[string]Profile)}
+	for k, v := range builtinProfiles {
+		p.list[k] = v
+	}
+	for k, v := range list {
+		p.list[k] = v
+	}
+	if name != "" {
+		if err := p.check(name); err != nil {
+			return nil, err
+		}
+		p.name = name
+	}
+	return p, nil
+}
+
+func (p *profileSet) check(name string) error {
+	if _, ok := p.list[name]; !ok {
+		return fmt.Errorf("unknown profile %q, want %s", name, strings.Join(p.names(), ", "))
+	}
+	return nil
+}
+
+func (p *profileSet) names() []string {
+	var names []string
+	for name := range p.list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// currentProfile returns the name and settings of the active profile.
+func (e *Engine) currentProfile() (string, Profile) {
+	e.profiles.Lock()
+	defer e.profiles.Unlock()
+	return e.profiles.name, e.profiles.list[e.profiles.name]
+}
+
+// Profile returns the active build profile and the names of all of them.
+func (e *Engine) Profile() (string, []string) {
+	e.profiles.Lock()
+	defer e.profiles.Unlock()
+	return e.profiles.name, e.profiles.names()
+}
+
+// SetProfile switches the active build profile and rebuilds every
+// target.
+func (e *Engine) SetProfile(name string) error {
+	e.profiles.Lock()
+	if err := e.profiles.check(name); err != nil {
+		e.profiles.Unlock()
+		return err
+	}
+	if name == e.profiles.name {
+		e.profiles.Unlock()
+		return nil
+	}
+	e.profiles.name = name
+	e.profiles.Unlock()
+
+	e.log.Printf("[INFO] Switch to profile %s\n", name)
+	e.Rebuild()
+	return nil
+}
+
+// newCoverDir creates the GOCOVERDIR of a single run of t.
+func (t *target) newCoverDir() (string, error) {
+	name := t.cfg.Name
+	if name == "" {
+		name = "run"
+	}
+	dir := filepath.Join(t.e.coverRoot, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// mergeCoverage merges the coverage data of all runs into coverOutput,
+// it does nothing if no run had the cover profile. Processes only write
+// their counters when they exit normally, so a program has to return
+// from main or call os.Exit on SIGINT.
+func (e *Engine) mergeCoverage() {
+	coverRoot := e.coverRoot
+	infos, err := ioutil.ReadDir(coverRoot)
+	if err != nil {
+		return
+	}
+
+	var dirs []string
+	for _, fi := range infos {
+		dir := filepath.Join(coverRoot, fi.Name())
+		if files, _ := ioutil.ReadDir(dir); fi.IsDir() && len(files) > 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		e.log.Println("[WARN] No coverage data, the program must exit normally on SIGINT to write it")
+		os.RemoveAll(coverRoot)
+		return
+	}
+
+	merged := filepath.Join(coverRoot, "merged")
+	if err := os.MkdirAll(merged, 0755); err != nil {
+		e.log.Printf("[ERROR] Merge coverage -> %v\n", err)
+		return
+	}
+
+	out := filepath.Join(e.dir, coverOutput)
+	steps := [][]string{
+		{"tool", "covdata", "merge", "-i=" + strings.Join(dirs, ","), "-o=" + merged},
+		{"tool", "covdata", "textfmt", "-i=" + merged, "-o=" + out},
+		{"tool", "covdata", "percent", "-i=" + merged},
+	}
+	for _, args := range steps {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = e.dir
+		cmd.Stdout = e.stdout
+		cmd.Stderr = e.stderr
+		if err := cmd.Run(); err != nil {
+			e.log.Printf("[ERROR] go %s -> %v, coverage data kept in %s\n", strings.Join(args[:3], " "), err, coverRoot)
+			return
+		}
+	}
+	os.RemoveAll(coverRoot)
+	e.log.Printf("[SUCCESS] Coverage of %d runs written to %s, view it with `go tool cover -html=%s`\n", len(dirs), out, coverOutput)
+}
@@ -0,0 +1,122 @@
+package autobuild
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// watchRegistry remembers which directories are watched, so that a
+// removed or renamed subtree can be forgotten without walking a path
+// that no longer exists.
+type watchRegistry struct {
+	w      watcher
+	prune  func(dir string) bool
+	follow bool
+	log    *logger
+	dirs   map[string]string // watched path -> real path
+	real   map[string]string // real path -> watched path
+	gauge  *metric           // number of watched directories
+	lock   sync.Mutex
+}
+
+func newWatchRegistry(w watcher, prune func(dir string) bool, follow bool, gauge *metric, l *logger) *watchRegistry {
+	return &watchRegistry{
+		w:      w,
+		log:    l,
+		prune:  prune,
+		follow: follow,
+		gauge:  gauge,
+		dirs:   make(map[string]string),
+		real:   make(map[string]string),
+	}
+}
+
+// addDir watches a single directory.
+func (r *watchRegistry) addDir(dir string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.dirs[dir]; ok {
+		return false
+	}
+	real := realDir(dir, r.follow)
+	if _, ok := r.real[real]; ok {
+		return false
+	}
+	if err := r.w.Add(dir); err != nil {
+		r.log.Printf("[ERROR] Failed to watch directory [ %s ] -> %v\n", dir, err)
+		if isWatchLimit(err) {
+			printLimitHelp(r.log, 0)
+		}
+		return false
+	}
+	r.dirs[dir] = real
+	r.real[real] = dir
+	r.gauge.set(float64(len(r.dirs)))
+	if fi, err := os.Lstat(dir); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		r.log.Printf("[INFO] Follow symlink %s -> %s\n", dir, real)
+	}
+	return true
+}
+
+// addTree watches root and every directory below it. Files are not
+// watched themselves, their events are reported by the parent directory.
+// Files found are passed to onFile if set, they may have been written
+// before the watch was in place. It returns the number of directories
+// added.
+func (r *watchRegistry) addTree(root string, onFile func(string)) int {
+	n := 0
+	walk(root, r.follow, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			r.log.Printf("[ERROR] %s", err)
+			return nil
+		}
+
+		if !info.IsDir() {
+			if onFile != nil {
+				onFile(path)
+			}
+			return nil
+		}
+
+		if r.prune(path) {
+			return filepath.SkipDir
+		}
+
+		if r.addDir(path) {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// removeTree forgets root and every watched directory below it.
+func (r *watchRegistry) removeTree(root string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	n := 0
+	prefix := root + string(filepath.Separator)
+	for dir, real := range r.dirs {
+		if dir == root || strings.HasPrefix(dir, prefix) {
+			// the kernel drops watches of deleted directories by itself
+			r.w.Remove(dir)
+			delete(r.dirs, dir)
+			delete(r.real, real)
+			n++
+		}
+	}
+	r.gauge.set(float64(len(r.dirs)))
+	return n
+}
+
+// watched reports whether dir is a watched directory.
+func (r *watchRegistry) watched(dir string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.dirs[dir]
+	return ok
+}
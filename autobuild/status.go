@@ -0,0 +1,208 @@
+package autobuild
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TargetState is the state of a target shown by Status.
+type TargetState string
+
+// The states of a target.
+const (
+	StateIdle     TargetState = "idle"
+	StateBuilding TargetState = "building"
+	StateFailed   TargetState = "failed"
+	StateRunning  TargetState = "running"
+	StateReady    TargetState = "ready"
+	StateStopped  TargetState = "stopped"
+	StateCrashed  TargetState = "crashed"
+	// StatePassed is an exec target whose last command succeeded.
+	StatePassed TargetState = "passed"
+)
+
+// TargetStatus is a snapshot of a target, built from the events the
+// engine emits.
+type TargetStatus struct {
+	Name   string
+	State  TargetState
+	Paused bool
+
+	// LastBuild is when the last build finished, BuildDuration how
+	// long it took.
+	LastBuild     time.Time
+	BuildDuration time.Duration
+	// Error and Errors are the reason and compiler errors of the last
+	// build if it failed.
+	Error  string
+	Errors []Diagnostic
+
+	PID      int
+	Restarts int
+}
+
+// statusBoard keeps the status of every target.
+type statusBoard struct {
+	sync.Mutex
+	targets map[string]*TargetStatus
+	starts  map[string]int
+	exec    map[string]bool
+}
+
+func (b *statusBoard) markExec(name string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.exec == nil {
+		b.exec = make(map[string]bool)
+	}
+	b.exec[name] = true
+}
+
+func (b *statusBoard) get(name string) *TargetStatus {
+	if b.targets == nil {
+		b.targets = make(map[string]*TargetStatus)
+		b.starts = make(map[string]int)
+	}
+	s := b.targets[name]
+	if s == nil {
+		s = &TargetStatus{Name: name, State: StateIdle}
+		b.targets[name] = s
+	}
+	return s
+}
+
+func (b *statusBoard) update(ev Event) {
+	if ev.Type == FileChanged {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	s := b.get(ev.Target)
+
+	switch ev.Type {
+	case BuildStarted:
+		s.State = StateBuilding
+	case BuildFailed:
+		s.State = StateFailed
+		s.LastBuild = ev.Time
+		s.BuildDuration = ev.Duration
+		s.Error = ev.Message
+		s.Errors = ev.Diagnostics
+	case BuildSucceeded:
+		if b.exec[ev.Target] {
+			s.State = StatePassed
+		}
+		s.LastBuild = ev.Time
+		s.BuildDuration = ev.Duration
+		s.Error = ""
+		s.Errors = nil
+	case ProcessStarted:
+		s.State = StateRunning
+		s.PID = ev.PID
+		if b.starts[ev.Target] > 0 {
+			s.Restarts++
+		}
+		b.starts[ev.Target]++
+	case ProcessReady:
+		s.State = StateReady
+	case ProcessExited:
+		if ev.PID != s.PID {
+			return
+		}
+		s.PID = 0
+		if ev.Crashed {
+			s.State = StateCrashed
+		} else if s.State == StateRunning || s.State == StateReady {
+			s.State = StateStopped
+		}
+	}
+}
+
+// Status returns the status of every target in start order.
+func (e *Engine) Status() []TargetStatus {
+	e.status.Lock()
+	defer e.status.Unlock()
+
+	var list []TargetStatus
+	for _, t := range e.targets {
+		s := *e.status.get(t.cfg.Name)
+		s.Errors = append([]Diagnostic{}, s.Errors...)
+		t.stateLock.Lock()
+		s.Paused = t.paused
+		t.stateLock.Unlock()
+		list = append(list, s)
+	}
+	return list
+}
+
+func (e *Engine) target(name string) (*target, error) {
+	for _, t := range e.targets {
+		if t.cfg.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown target %q", name)
+}
+
+// RebuildTarget builds and restarts the target name regardless of the
+// build interval.
+func (e *Engine) RebuildTarget(name string) error {
+	t, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	go t.rebuild()
+	return nil
+}
+
+// Restart restarts the process of the target name without building.
+func (e *Engine) Restart(name string) error {
+	t, err := e.target(name)
+	if err != nil {
+		return err
+	}
+	go t.restart(nil)
+	return nil
+}
+
+// Pause stops or resumes building the target name on changes. The
+// changes made while paused are built on resume.
+func (e *Engine) Pause(name string, paused bool) error {
+	t, err := e.target(name)
+	if err != nil {
+		return err
+	}
+
+	t.stateLock.Lock()
+	t.paused = paused
+	pending := t.pending
+	t.pending = nil
+	t.stateLock.Unlock()
+
+	if paused {
+		t.log.Printf("[INFO] %sPaused\n", t.tag())
+		return nil
+	}
+	t.log.Printf("[INFO] %sResumed\n", t.tag())
+	if len(pending) > 0 {
+		go t.autobuild(pending...)
+	}
+	return nil
+}
+
+// hold keeps files for later while the target is paused.
+func (t *target) hold(files ...string) bool {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	if !t.paused {
+		return false
+	}
+	for _, f := range files {
+		if !contains(t.pending, f) {
+			t.pending = append(t.pending, f)
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package autobuild
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+// The events an engine emits.
+const (
+	FileChanged    EventType = "file_changed"
+	BuildStarted   EventType = "build_started"
+	BuildFailed    EventType = "build_failed"
+	BuildSucceeded EventType = "build_succeeded"
+	ProcessStarted EventType = "process_started"
+	ProcessReady   EventType = "process_ready"
+	ProcessExited  EventType = "process_exited"
+)
+
+// eventBuffer is the channel size of the event streams.
+const eventBuffer = 256
+
+// Event reports a change of state of the engine or one of its targets.
+// Only the fields that apply to its Type are set.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Target  string
+	Files   []string
+	Message string
+
+	// Duration is how long the build of BuildSucceeded and BuildFailed
+	// took, or how long the process of ProcessReady took to get ready.
+	Duration time.Duration
+	// Diagnostics are the compiler errors of BuildFailed.
+	Diagnostics []Diagnostic
+	// PID is the process of ProcessStarted, ProcessReady and
+	// ProcessExited.
+	PID int
+	// ExitCode is set for BuildFailed and ProcessExited. Crashed is set
+	// when the process was not stopped by the engine.
+	ExitCode int
+	Crashed  bool
+}
+
+type eventJSON struct {
+	Type        EventType    `json:"type"`
+	Time        time.Time    `json:"time"`
+	Target      string       `json:"target,omitempty"`
+	Files       []string     `json:"files,omitempty"`
+	Message     string       `json:"message,omitempty"`
+	DurationMs  float64      `json:"duration_ms,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	PID         int          `json:"pid,omitempty"`
+	ExitCode    *int         `json:"exit_code,omitempty"`
+	Crashed     bool         `json:"crashed,omitempty"`
+}
+
+// MarshalJSON encodes the event with snake_case keys and the duration
+// in milliseconds, fields that do not apply are left out.
+func (ev Event) MarshalJSON() ([]byte, error) {
+	v := eventJSON{
+		Type:        ev.Type,
+		Time:        ev.Time,
+		Target:      ev.Target,
+		Files:       ev.Files,
+		Message:     ev.Message,
+		DurationMs:  ms(ev.Duration),
+		Diagnostics: ev.Diagnostics,
+		PID:         ev.PID,
+		Crashed:     ev.Crashed,
+	}
+	if ev.Type == BuildFailed || ev.Type == ProcessExited {
+		code := ev.ExitCode
+		v.ExitCode = &code
+	}
+	return json.Marshal(v)
+}
+
+// subscribers are the channels returned by Subscribe.
+type subscribers struct {
+	sync.Mutex
+	chans map[chan Event]bool
+}
+
+// Subscribe returns a channel receiving every event and a function that
+// ends the subscription. Events are dropped while the channel is full.
+func (e *Engine) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	e.subs.Lock()
+	if e.subs.chans == nil {
+		e.subs.chans = make(map[chan Event]bool)
+	}
+	e.subs.chans[ch] = true
+	e.subs.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.subs.Lock()
+			delete(e.subs.chans, ch)
+			e.subs.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (e *Engine) emit(ev Event) {
+	ev.Time = time.Now()
+	e.status.update(ev)
+	if e.opts.OnEvent != nil {
+		e.opts.OnEvent(ev)
+	}
+
+	e.subs.Lock()
+	defer e.subs.Unlock()
+	for ch := range e.subs.chans {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// WriteEvents writes events to w as newline delimited JSON until the
+// channel is closed or a write fails.
+func WriteEvents(w io.Writer, events <-chan Event) error {
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeEvents streams the events as newline delimited JSON to every
+// connection accepted on l, until l is closed.
+func (e *Engine) ServeEvents(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			events, cancel := e.Subscribe(eventBuffer)
+			defer cancel()
+			defer conn.Close()
+
+			// the stream is one way, a read returns once the client is gone
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				cancel()
+			}()
+			WriteEvents(conn, events)
+		}()
+	}
+}
+
+// EventsHandler streams the events as Server-Sent Events, the event
+// name is the type and the data its JSON encoding.
+func (e *Engine) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events, cancel := e.Subscribe(eventBuffer)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case ev := <-events:
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package autobuild
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// walk is filepath.Walk that, with follow set, also descends into
+// symlinked directories. Paths are reported as seen through the link.
+// Every real directory is visited once, which breaks symlink cycles and
+// skips directories linked into the tree more than once.
+func walk(root string, follow bool, fn filepath.WalkFunc) error {
+	if !follow {
+		return filepath.Walk(root, fn)
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = walkPath(root, info, fn, make(map[string]bool))
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkPath(path string, info os.FileInfo, fn filepath.WalkFunc, visited map[string]bool) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(path); err == nil && target.IsDir() {
+			info = target
+		}
+	}
+
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	if visited[real] {
+		return nil
+	}
+	visited[real] = true
+
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	for _, fi := range infos {
+		err := walkPath(filepath.Join(path, fi.Name()), fi, fn, visited)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// realDir resolves the directory a watched path points to when
+// following symlinks, directories reachable through several symlinks
+// are watched only once.
+func realDir(dir string, follow bool) string {
+	if !follow {
+		return dir
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir
+	}
+	return real
+}
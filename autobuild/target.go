@@ -0,0 +1,625 @@
+package autobuild
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// target is a supervised program: its build, its running process and
+// the subset of changes it reacts to.
+type target struct {
+	e       *Engine
+	cfg     TargetConfig
+	binName string
+	log     *logger
+
+	envFiles      map[string]bool
+	buildEnvFiles map[string]bool
+	roots         []string
+	ignore        []string
+	extMap        map[string]bool
+	deps          *depGraph
+	stdout        io.Writer
+	stderr        io.Writer
+	buildTime     time.Time
+	queued        []string
+	queueTimer    *time.Timer
+	envTime       time.Time
+	envTimer      *time.Timer
+	cmd           *exec.Cmd
+	exited        chan struct{}
+	graceful      bool
+	stopping      chan struct{}
+	cycle         *buildRecord
+	gen           int
+	lock          sync.Mutex
+	procLock      sync.Mutex
+
+	dependsOn  []*targetDep
+	dependents []*target
+	startedCh  chan struct{}
+	readyCh    chan struct{}
+	paused     bool
+	pending    []string
+	execCancel func()
+	execDone   chan struct{}
+	stateLock  sync.Mutex
+}
+
+func newTarget(e *Engine, cfg TargetConfig) (*target, error) {
+	t := &target{
+		e:      e,
+		cfg:    cfg,
+		log:    newLogger(e.opts, cfg.Name),
+		extMap: make(map[string]bool),
+
+		envFiles:      make(map[string]bool),
+		buildEnvFiles: make(map[string]bool),
+		stdout:        e.stdout,
+		stderr:        e.stderr,
+
+		startedCh: make(chan struct{}),
+		readyCh:   make(chan struct{}),
+	}
+
+	// a custom build command builds a package we do not know
+	if cfg.Pkg == "" && cfg.Build == "" {
+		t.cfg.Pkg = "."
+	}
+
+	t.binName = appName
+	if cfg.Name != "" {
+		t.binName += "_" + cfg.Name
+		t.stdout = newPrefixWriter(e.stdout, cfg.Name)
+		t.stderr = newPrefixWriter(e.stderr, cfg.Name)
+	}
+	if e.opts.Output != nil {
+		w := e.opts.Output(cfg.Name)
+		t.stdout, t.stderr = w, w
+	}
+	if runtime.GOOS == "windows" {
+		t.binName += ".exe"
+	}
+
+	t.roots = append(t.roots, e.dir)
+	for _, v := range cfg.Watch.Dirs {
+		dir, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		t.roots = append(t.roots, dir)
+	}
+
+	for _, v := range cfg.Watch.Ignore {
+		dir, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		t.ignore = append(t.ignore, dir)
+	}
+
+	for _, v := range cfg.Watch.Exts {
+		if strings.TrimSpace(v) != "" {
+			t.extMap[v] = true
+		}
+	}
+
+	for _, v := range cfg.Env.Files {
+		file, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		t.envFiles[file] = true
+	}
+
+	for _, v := range cfg.BuildEnv.Files {
+		file, err := e.absPath(v)
+		if err != nil {
+			return nil, err
+		}
+		t.buildEnvFiles[file] = true
+	}
+
+	e.hooks.register(cfg.Hooks)
+	t.deps = &depGraph{e: e, tag: t.tag(), log: t.log, pkg: t.cfg.Pkg}
+	return t, nil
+}
+
+// tag is prepended to log messages of named targets.
+func (t *target) tag() string {
+	if t.cfg.Name == "" {
+		return ""
+	}
+	return "[" + t.cfg.Name + "] "
+}
+
+// watches reports whether path is below one of the directories the
+// target watches: its roots, the -w directories and the local modules.
+func (t *target) watches(path string) bool {
+	return underAny(path, t.roots) || underAny(path, t.e.watchDirs) || t.e.inLocalModule(path)
+}
+
+// match reports whether file falls under the target's watch rules.
+func (t *target) match(file string) bool {
+	if !t.watches(file) || underAny(file, t.ignore) {
+		return false
+	}
+	if len(t.extMap) == 0 || isModuleChange(file) {
+		return true
+	}
+	return t.extMap[filepath.Ext(file)]
+}
+
+// trigger rebuilds unless every file is outside the dependency closure.
+func (t *target) trigger(files []string) {
+	if t.hold(files...) {
+		return
+	}
+	var affected []string
+	for _, file := range files {
+		if t.filterDeps() && !t.deps.affected(file) {
+			t.log.Printf("[INFO] %sSkip, not imported by target: %s\n", t.tag(), file)
+			continue
+		}
+		affected = append(affected, file)
+	}
+	if len(affected) > 0 {
+		t.autobuild(affected...)
+	}
+}
+
+// filterDeps reports whether only changes to the imported packages
+// trigger a build. Exec commands and custom builds without pkg run on
+// every change.
+func (t *target) filterDeps() bool {
+	return !t.e.opts.AllChanges && t.cfg.Exec == "" && t.cfg.Pkg != ""
+}
+
+// triggerTree rebuilds after the directory dir was removed.
+func (t *target) triggerTree(dir string) {
+	if t.hold(dir) {
+		return
+	}
+	if t.filterDeps() && !t.deps.affectsTree(dir) {
+		t.log.Printf("[INFO] %sSkip, not imported by target: %s\n", t.tag(), dir)
+		return
+	}
+	t.autobuild(dir)
+}
+
+// autobuild builds and restarts the target, files are the changes that
+// caused it. Changes within intervalTime of the last build are queued
+// and built together once the interval has passed.
+func (t *target) autobuild(files ...string) {
+	if t.cfg.Exec != "" {
+		t.runExec(files)
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if wait := intervalTime - time.Since(t.buildTime); wait > 0 {
+		t.queue(files, wait)
+		return
+	}
+
+	if t.queueTimer != nil {
+		t.queueTimer.Stop()
+		t.queueTimer = nil
+		files = merge(files, t.queued)
+		t.queued = nil
+	}
+
+	t.buildTime = time.Now()
+	rec := t.newRecord(files)
+	if t.build(rec) {
+		t.restart(rec)
+	}
+}
+
+// queue keeps files for the build after wait, t.lock must be held.
+func (t *target) queue(files []string, wait time.Duration) {
+	t.queued = merge(t.queued, files)
+	if t.queueTimer != nil {
+		return
+	}
+	t.log.Printf("[INFO] %sBuilt less than %s ago, build again in %s\n", t.tag(), intervalTime, round(wait))
+	t.queueTimer = time.AfterFunc(wait, func() {
+		t.lock.Lock()
+		files := t.queued
+		t.queued = nil
+		t.queueTimer = nil
+		t.lock.Unlock()
+		if !t.e.stopped() {
+			t.autobuild(files...)
+		}
+	})
+}
+
+// merge appends the files of b missing from a.
+func merge(a, b []string) []string {
+	for _, f := range b {
+		if !contains(a, f) {
+			a = append(a, f)
+		}
+	}
+	return a
+}
+
+// build runs the pre-build hooks, the compiler and the post-build hooks
+// and reports whether all of them succeeded, a failure completes rec.
+func (t *target) build(rec *buildRecord) bool {
+	files := rec.Files
+	_, prof := t.e.currentProfile()
+	env, err := t.cfg.BuildEnv.environ(t.e.dir, append(os.Environ(), prof.Env...))
+	if err != nil {
+		t.log.Printf("[ERROR] %sBuild env -> %v\n", t.tag(), err)
+		t.fail(rec, "env error", -1, nil)
+		return false
+	}
+
+	stage := time.Now()
+	err = t.runHooks("pre-build", t.cfg.Hooks.PreBuild, env)
+	if len(t.cfg.Hooks.PreBuild) > 0 {
+		rec.stage("pre-build", stage)
+	}
+	if err != nil {
+		t.log.Printf("[ERROR] %s================Build failed=================\n", t.tag())
+		t.fail(rec, "hook failed", exitCode(err), nil)
+		return false
+	}
+
+	var cmd *exec.Cmd
+	if t.cfg.Build != "" {
+		cmd = shell(t.cfg.Build)
+		cmd.Dir = t.e.dir
+	} else {
+		dir, pkg, err := t.e.resolvePkg(t.cfg.Pkg)
+		if err != nil {
+			t.log.Printf("[ERROR] %s%v\n", t.tag(), err)
+			t.fail(rec, "module error", -1, nil)
+			return false
+		}
+
+		args := []string{"build"}
+
+		if t.e.opts.Mod != "" {
+			args = append(args, "-mod", t.e.opts.Mod)
+		}
+
+		args = append(args, prof.Flags...)
+
+		if t.cfg.Debug != "" {
+			args = append(args, debugGCFlags...)
+		}
+
+		args = append(args, "-o", filepath.Join(t.e.dir, t.binName), pkg)
+		cmd = exec.Command("go", args...)
+		cmd.Dir = dir
+	}
+
+	var output bytes.Buffer
+	cmd.Env = env
+	cmd.Stdout = t.stdout
+	cmd.Stderr = io.MultiWriter(t.stderr, &output)
+	t.log.Printf("[INFO] %sStart building...\n", t.tag())
+	t.e.emit(Event{Type: BuildStarted, Target: t.cfg.Name, Files: files})
+	stage = time.Now()
+	err = cmd.Run()
+	rec.stage("compile", stage)
+
+	if err != nil {
+		if isModuleError(output.String()) {
+			t.log.Printf("[ERROR] %s================Module resolution failed=================\n", t.tag())
+			t.log.Printf("[INFO] %sCheck go.mod/go.sum, run `go mod tidy` or start with -modsync tidy\n", t.tag())
+			t.fail(rec, "module error", exitCode(err), nil)
+			return false
+		}
+		t.log.Printf("[ERROR] %s================Build failed=================\n", t.tag())
+		t.fail(rec, "failed", exitCode(err), parseDiagnostics(cmd.Dir, output.String()))
+		return false
+	}
+
+	t.log.Printf("[SUCCESS] %sBuild success\n", t.tag())
+	t.e.emit(Event{Type: BuildSucceeded, Target: t.cfg.Name, Files: files, Duration: time.Since(rec.Start)})
+
+	stage = time.Now()
+	err = t.runHooks("post-build", t.cfg.Hooks.PostBuild, env)
+	if len(t.cfg.Hooks.PostBuild) > 0 {
+		rec.stage("post-build", stage)
+	}
+	if err != nil {
+		t.fail(rec, "hook failed", exitCode(err), nil)
+		return false
+	}
+	return true
+}
+
+// fail reports a failed build and completes rec with result.
+func (t *target) fail(rec *buildRecord, result string, code int, diags []Diagnostic) {
+	t.e.emit(Event{
+		Type:        BuildFailed,
+		Target:      t.cfg.Name,
+		Files:       rec.Files,
+		Message:     result,
+		Duration:    time.Since(rec.Start),
+		Diagnostics: diags,
+		ExitCode:    code,
+	})
+	rec.finish(result, code)
+}
+
+// rebuild builds regardless of the build interval.
+func (t *target) rebuild() {
+	t.lock.Lock()
+	t.buildTime = time.Time{}
+	t.lock.Unlock()
+	t.autobuild()
+}
+
+// restart replaces the running process. Dependents that cascade are
+// stopped first and start again once t reaches their condition. The
+// stages of the restart are added to rec, which may be nil.
+func (t *target) restart(rec *buildRecord) {
+	if t.cfg.Exec != "" {
+		go t.runExec(nil)
+		return
+	}
+
+	stage := time.Now()
+	dependents := t.cascadeDependents()
+	for _, d := range dependents {
+		t.log.Printf("[INFO] %sStop dependent %s\n", t.tag(), d.cfg.Name)
+		d.kill()
+	}
+
+	t.log.Printf("[INFO] %sKill running process\n", t.tag())
+	t.kill()
+	rec.stage("stop", stage)
+
+	t.procLock.Lock()
+	t.cycle = rec
+	t.procLock.Unlock()
+	go t.start()
+
+	for _, d := range dependents {
+		go d.start()
+	}
+}
+
+// running reports whether the target has a live process.
+func (t *target) running() bool {
+	t.procLock.Lock()
+	defer t.procLock.Unlock()
+	return t.cmd != nil && !isClosed(t.exited)
+}
+
+func (t *target) kill() {
+	if t.cfg.Exec != "" {
+		t.stopExec()
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			t.log.Printf("[ERROR] %sKill failed recover -> %v\n", t.tag(), err)
+		}
+	}()
+
+	t.procLock.Lock()
+	defer t.procLock.Unlock()
+
+	t.gen++
+	t.resetState()
+	t.log.Printf("[INFO] %sKilling process\n", t.tag())
+
+	if t.cmd != nil && t.cmd.Process != nil {
+		close(t.stopping)
+		var err error
+		if t.graceful {
+			err = t.interrupt()
+		} else {
+			err = t.cmd.Process.Kill()
+		}
+		if err != nil {
+			select {
+			case <-t.exited:
+				// it had exited on its own, the signal found nothing
+			case <-time.After(time.Second):
+				t.log.Printf("[ERROR] %sKill process -> %v\n", t.tag(), err)
+			}
+		}
+		<-t.exited
+		t.cmd = nil
+		t.log.Printf("[SUCCESS] %sKill process success\n", t.tag())
+		env, _ := t.cfg.Env.environ(t.e.dir, os.Environ())
+		t.runHooks("post-stop", t.cfg.Hooks.PostStop, env)
+		return
+	}
+	t.log.Printf("[info] %sthis process is nil\n", t.tag())
+}
+
+// interrupt asks the process to exit and kills it after stopTimeout. A
+// debugger needs it to release its port, a covered program to write its
+// coverage data.
+func (t *target) interrupt() error {
+	if err := t.cmd.Process.Signal(os.Interrupt); err != nil {
+		return t.cmd.Process.Kill()
+	}
+
+	select {
+	case <-t.exited:
+		return nil
+	case <-time.After(stopTimeout):
+		t.log.Printf("[WARN] %sProcess did not exit in %s, killing it\n", t.tag(), stopTimeout)
+		return t.cmd.Process.Kill()
+	}
+}
+
+// start runs the target once its dependencies are up. A start that was
+// superseded by a kill while waiting is dropped.
+func (t *target) start() {
+	t.procLock.Lock()
+	gen := t.gen
+	t.procLock.Unlock()
+
+	t.waitDeps()
+
+	t.procLock.Lock()
+	defer t.procLock.Unlock()
+	rec := t.cycle
+	t.cycle = nil
+	if gen != t.gen || t.cmd != nil || t.e.stopped() {
+		rec.finish("cancelled", 0)
+		return
+	}
+
+	stage := time.Now()
+	env, err := t.cfg.Env.environ(t.e.dir, os.Environ())
+	if err != nil {
+		t.log.Printf("[ERROR] %sRun env -> %v\n", t.tag(), err)
+		rec.finish("env error", -1)
+		return
+	}
+
+	if err := t.runHooks("pre-start", t.cfg.Hooks.PreStart, env); err != nil {
+		rec.finish("hook failed", exitCode(err))
+		return
+	}
+
+	_, prof := t.e.currentProfile()
+	if prof.Cover {
+		dir, err := t.newCoverDir()
+		if err != nil {
+			t.log.Printf("[ERROR] %sCoverage dir -> %v\n", t.tag(), err)
+			rec.finish("start failed", -1)
+			return
+		}
+		env = append(env, "GOCOVERDIR="+dir)
+	}
+
+	name := filepath.Join(t.e.dir, t.binName)
+	args := t.cfg.Args
+	if t.cfg.Debug != "" {
+		t.log.Printf("[INFO] %sDebugger listening on %s\n", t.tag(), t.cfg.Debug)
+		name, args = dlvCommand(t.cfg.Debug, name, args)
+	}
+
+	var cmd *exec.Cmd
+	if t.cfg.Run != "" {
+		t.log.Printf("[INFO] %sRestarting %s %s ...\n", t.tag(), t.cfg.Run, strings.Join(args, " "))
+		cmd = shellExec(t.cfg.Run, args)
+	} else {
+		t.log.Printf("[INFO] %sRestarting %s %s ...\n", t.tag(), name, strings.Join(args, " "))
+		cmd = exec.Command(name, args...)
+	}
+	cmd.Dir = t.e.dir
+	cmd.Stdout = t.stdout
+	cmd.Stderr = t.stderr
+	cmd.Env = env
+
+	var matcher *logMatcher
+	if t.cfg.Ready.Log != "" {
+		matcher = &logMatcher{pattern: []byte(t.cfg.Ready.Log)}
+		cmd.Stdout = io.MultiWriter(t.stdout, matcher)
+		cmd.Stderr = io.MultiWriter(t.stderr, matcher)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.log.Printf("[ERROR] %sStart process -> %v\n", t.tag(), err)
+		rec.finish("start failed", -1)
+		return
+	}
+	rec.stage("start", stage)
+
+	exited := make(chan struct{})
+	stopping := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		// report the exit before a restart waiting on exited starts the
+		// next process, or the metrics would end up down
+		m := t.e.metrics
+		m.processUp.set(0, t.cfg.Name)
+		crashed := !isClosed(stopping)
+		if crashed {
+			t.log.Printf("[ERROR] %sProcess exited unexpectedly (%s)\n", t.tag(), cmd.ProcessState)
+			m.processCrashes.add(1, t.cfg.Name)
+			t.e.notify(notifyCrashed, t.cfg.Name, fmt.Sprintf("Process exited unexpectedly (%s)", cmd.ProcessState))
+		}
+		t.e.emit(Event{Type: ProcessExited, Target: t.cfg.Name, PID: cmd.Process.Pid, ExitCode: cmd.ProcessState.ExitCode(), Crashed: crashed})
+		close(exited)
+	}()
+	t.e.metrics.processStarts.add(1, t.cfg.Name)
+	t.e.metrics.processUp.set(1, t.cfg.Name)
+	t.e.emit(Event{Type: ProcessStarted, Target: t.cfg.Name, PID: cmd.Process.Pid})
+
+	t.cmd = cmd
+	t.exited = exited
+	t.stopping = stopping
+	t.graceful = t.cfg.Debug != "" || prof.Cover
+	t.markStarted()
+	go t.probe(matcher, cmd, exited, stopping, rec)
+	t.log.Printf("[INFO] %s%s is running...\n", t.tag(), name)
+}
+
+// isBinary reports whether file is the output of one of the targets.
+func isBinary(file string) bool {
+	return strings.HasPrefix(filepath.Base(file), appName)
+}
+
+func underAny(file string, dirs []string) bool {
+	for _, dir := range dirs {
+		if file == dir || strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath resolves p relative to dir.
+func absPath(dir, p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return filepath.Abs(filepath.Clean(p))
+}
+
+// prefixWriter prefixes every line written to w with the target name.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+	lock   sync.Mutex
+}
+
+func newPrefixWriter(w io.Writer, name string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(name + " | ")}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := append(append([]byte{}, p.prefix...), p.buf[:i+1]...)
+		if _, err := p.w.Write(line); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
@@ -0,0 +1,146 @@
+package autobuild
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher delivers file system events for the paths added to it. Like
+// fsnotify, adding a directory reports changes of its direct entries.
+type watcher interface {
+	Add(path string) error
+	Remove(path string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// newWatcher creates a backend: "fsnotify", "poll" or "auto", which uses
+// fsnotify and polls whatever the kernel refuses to watch. Polls run
+// every interval and compare contents with hash set.
+func newWatcher(backend string, interval time.Duration, hash bool, l *logger) (watcher, error) {
+	switch backend {
+	case "poll":
+		return newPollWatcher(interval, hash), nil
+	case "fsnotify":
+		return newNotifyWatcher()
+	case "auto", "":
+		w, err := newNotifyWatcher()
+		if err != nil {
+			if isWatchLimit(err) {
+				l.Printf("[WARN] fsnotify unavailable (%v), falling back to polling\n", err)
+				return newPollWatcher(interval, hash), nil
+			}
+			return nil, err
+		}
+		return newHybridWatcher(w, newPollWatcher(interval, hash), l), nil
+	}
+	return nil, fmt.Errorf("unknown watcher %q, want auto, fsnotify or poll", backend)
+}
+
+// isWatchLimit reports whether err means the kernel ran out of watches
+// or watcher instances.
+func isWatchLimit(err error) bool {
+	return err == syscall.ENOSPC || err == syscall.EMFILE
+}
+
+// notifyWatcher is the fsnotify backend.
+type notifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func newNotifyWatcher() (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &notifyWatcher{w: w}, nil
+}
+
+func (n *notifyWatcher) Add(path string) error         { return n.w.Add(path) }
+func (n *notifyWatcher) Remove(path string) error      { return n.w.Remove(path) }
+func (n *notifyWatcher) Events() <-chan fsnotify.Event { return n.w.Events }
+func (n *notifyWatcher) Errors() <-chan error          { return n.w.Errors }
+func (n *notifyWatcher) Close() error                  { return n.w.Close() }
+
+// hybridWatcher uses fsnotify and moves paths to a poll watcher once the
+// kernel watch limit is reached.
+type hybridWatcher struct {
+	notify *notifyWatcher
+	poll   *pollWatcher
+	polled map[string]bool
+	log    *logger
+	events chan fsnotify.Event
+	errors chan error
+	lock   sync.Mutex
+}
+
+func newHybridWatcher(n *notifyWatcher, p *pollWatcher, l *logger) *hybridWatcher {
+	h := &hybridWatcher{
+		log:    l,
+		notify: n,
+		poll:   p,
+		polled: make(map[string]bool),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+	}
+	go h.forward(n)
+	go h.forward(h.poll)
+	return h
+}
+
+func (h *hybridWatcher) forward(w watcher) {
+	for {
+		select {
+		case event, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			h.events <- event
+		case err, ok := <-w.Errors():
+			if !ok {
+				return
+			}
+			h.errors <- err
+		}
+	}
+}
+
+func (h *hybridWatcher) Add(path string) error {
+	err := h.notify.Add(path)
+	if err == nil || !isWatchLimit(err) {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.polled) == 0 {
+		h.log.Printf("[WARN] fsnotify watch limit reached (%v), polling the remaining paths\n", err)
+	}
+	h.polled[path] = true
+	return h.poll.Add(path)
+}
+
+func (h *hybridWatcher) Remove(path string) error {
+	h.lock.Lock()
+	polled := h.polled[path]
+	delete(h.polled, path)
+	h.lock.Unlock()
+
+	if polled {
+		return h.poll.Remove(path)
+	}
+	return h.notify.Remove(path)
+}
+
+func (h *hybridWatcher) Events() <-chan fsnotify.Event { return h.events }
+func (h *hybridWatcher) Errors() <-chan error          { return h.errors }
+
+func (h *hybridWatcher) Close() error {
+	h.poll.Close()
+	return h.notify.Close()
+}
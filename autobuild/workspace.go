@@ -0,0 +1,101 @@
+package autobuild
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// workspace is the go.work in use, if any, and its modules.
+type workspace struct {
+	sync.Mutex
+	file    string
+	modules []string
+	log     *logger
+}
+
+func (w *workspace) set(file string, modules []string) {
+	w.Lock()
+	defer w.Unlock()
+
+	if file != w.file {
+		if file != "" {
+			w.log.Printf("[INFO] Workspace mode, %s uses %d modules\n", file, len(modules))
+		} else if w.file != "" {
+			w.log.Println("[INFO] Workspace mode off")
+		}
+	}
+	w.file = file
+	w.modules = modules
+}
+
+func (w *workspace) get() (string, []string) {
+	w.Lock()
+	defer w.Unlock()
+	return w.file, w.modules
+}
+
+// checkWorkspace rejects build flags that conflict with workspace mode.
+func (e *Engine) checkWorkspace() error {
+	file, _ := e.work.get()
+
+	if file == "" {
+		return nil
+	}
+
+	mod := e.opts.Mod
+	switch mod {
+	case "", "readonly":
+	case "vendor":
+		vendor := filepath.Join(filepath.Dir(file), "vendor", "modules.txt")
+		if _, err := os.Stat(vendor); err != nil {
+			return fmt.Errorf("-mod=vendor in workspace mode needs the vendor directory of the workspace, "+
+				"run `go work vendor` in %s, or drop -mod to build from the module cache", filepath.Dir(file))
+		}
+	default:
+		return fmt.Errorf("-mod=%s can not be used in workspace mode (%s), "+
+			"use -mod=readonly or -mod=vendor, or set GOWORK=off to build the module alone", mod, file)
+	}
+
+	for _, f := range strings.Fields(os.Getenv("GOFLAGS")) {
+		if f == "-mod=mod" {
+			return fmt.Errorf("GOFLAGS=%q can not be used in workspace mode (%s), "+
+				"remove -mod=mod from GOFLAGS or set GOWORK=off", os.Getenv("GOFLAGS"), file)
+		}
+	}
+	return nil
+}
+
+// resolvePkg returns the directory to run go build in and the package
+// to build. In workspace mode a relative package is built from the
+// workspace module that contains it.
+func (e *Engine) resolvePkg(pkg string) (string, string, error) {
+	file, modules := e.work.get()
+
+	if file == "" || !isLocalPath(pkg) && pkg != "." {
+		return e.dir, pkg, nil
+	}
+
+	dir, err := e.absPath(pkg)
+	if err != nil {
+		return "", "", err
+	}
+
+	module := ""
+	for _, m := range modules {
+		if underAny(dir, []string{m}) && len(m) > len(module) {
+			module = m
+		}
+	}
+	if module == "" {
+		return "", "", fmt.Errorf("package %s is not in a module used by %s", dir, file)
+	}
+
+	rel, err := filepath.Rel(module, dir)
+	if err != nil {
+		return "", "", err
+	}
+	return module, "./" + filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/iwannay/goautobuild/autobuild"
+)
+
+// control reads commands typed while goautobuild runs, one per line.
+func control(r io.Reader, e *autobuild.Engine) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "r", "rebuild":
+			e.Rebuild()
+		case "p", "profile":
+			if len(fields) == 1 {
+				name, names := e.Profile()
+				log.Printf("[INFO] Profile %s, available: %s\n", name, strings.Join(names, ", "))
+				continue
+			}
+			if err := e.SetProfile(fields[1]); err != nil {
+				log.Printf("[ERROR] %v\n", err)
+			}
+		case "history":
+			if len(fields) == 1 {
+				e.PrintHistory(10)
+				continue
+			}
+			if err := e.ExportHistory(fields[1]); err != nil {
+				log.Printf("[ERROR] Export history -> %v\n", err)
+				continue
+			}
+			log.Printf("[INFO] History written to %s\n", fields[1])
+		case "h", "help":
+			log.Println("[INFO] Commands: rebuild (r), profile [name] (p), history [file.json|file.csv], help (h)")
+		default:
+			log.Printf("[WARN] Unknown command %q, type help\n", fields[0])
+		}
+	}
+}
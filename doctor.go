@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/iwannay/goautobuild/autobuild"
+)
+
+// doctorCommand checks the environment for the same flags as run and
+// exits with 1 if any check fails.
+func doctorCommand(args []string) {
+	fs := newFlagSet("doctor")
+	projectFlags(fs)
+	watchFlags(fs)
+	parseFlags(fs, args)
+
+	var checks []autobuild.Check
+	opts, err := loadProject(fs)
+	if err == nil {
+		_, err = autobuild.New(opts)
+	}
+	if err != nil {
+		checks = append(checks, autobuild.Check{Name: "config", Status: autobuild.CheckFail, Message: err.Error()})
+		// check the rest of the environment with the defaults
+		opts.Config = autobuild.Config{}
+	} else if configArg != "" {
+		checks = append(checks, autobuild.Check{Name: "config", Status: autobuild.CheckOK, Message: configArg})
+	}
+
+	engine, err := autobuild.New(opts)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+
+	var addrs []string
+	if metricsArg != "" {
+		addrs = append(addrs, metricsArg)
+	}
+	if streams, err := parseEventStreams(eventsArg); err == nil {
+		addrs = append(addrs, streams.sse...)
+	}
+	checks = append(checks, engine.Doctor(addrs...)...)
+
+	failed := false
+	for _, c := range checks {
+		fmt.Printf("%-7s %-16s %s\n", "["+strings.ToUpper(string(c.Status))+"]", c.Name, c.Message)
+		failed = failed || c.Status == autobuild.CheckFail
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -2,7 +2,7 @@ module github.com/iwannay/goautobuild
 
 require (
 	github.com/fsnotify/fsnotify v1.4.7
-	golang.org/x/sys v0.0.0-20180326154331-13d03a9a82fb // indirect
+	golang.org/x/sys v0.0.0-20180326154331-13d03a9a82fb
 )
 
 go 1.13
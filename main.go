@@ -1,53 +1,55 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/iwannay/goautobuild/autobuild"
 )
 
 var (
-	watchPathArg string
-	watchExtsArg string
-	ignoreDirArg string
-	ignoreDirArr []string
-	watchDirArg  string
-	mod          string
-	cmdArgs      string
-	cmdArgsArr   []string
-	printHelp    bool
-	extMap       = make(map[string]bool, 0)
-	watchPath    string
-	buildTime    time.Time
-	cmd          *exec.Cmd
-	lock         sync.Mutex
+	watchPathArg   string
+	watchExtsArg   string
+	ignoreDirArg   string
+	ignoreDirArr   []string
+	watchDirArg    string
+	watchDirs      []string
+	modSyncArg     string
+	debugArg       string
+	profileArg     string
+	historyArg     string
+	metricsArg     string
+	notifyArg      string
+	notifyOnArg    string
+	webhookArg     string
+	eventsArg      string
+	tuiArg         bool
+	execArg        string
+	mod            string
+	cmdArgs        string
+	printHelp      bool
+	affectedOnly   bool
+	configArg      string
+	envArg         string
+	envFileArg     string
+	buildEnvArg    string
+	watcherArg     string
+	pollInterval   time.Duration
+	pollHash       bool
+	batchDelay     time.Duration
+	followSymlinks bool
+	compareContent bool
+	watchPath      string
 )
 
-const (
-	intervalTime = 3 * time.Second
-	appName      = "binTmp"
-)
-
-func checkFile(file string) bool {
-	if len(extMap) == 0 {
-		return true
-	}
-	ext := filepath.Ext(file)
-	return extMap[ext]
-
-}
-
 func rename(oldpath, newpath string) error {
 	finfo, err := os.Stat(oldpath)
 	if !os.IsNotExist(err) {
@@ -65,267 +67,418 @@ func rename(oldpath, newpath string) error {
 }
 
 func listenSignal(fn func()) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
-	for {
-		sign := <-c
-		log.Println("get signal:", sign)
-		if fn != nil {
-			fn()
-		}
-		log.Fatal("trying to exit gracefully...")
+	sign := <-c
+	log.Println("get signal:", sign)
+	fn()
+	// a second signal exits without waiting for the processes
+	<-c
+	log.Fatal("exit without stopping the processes")
+}
 
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
 	}
+	return list
 }
 
-func autobuild() {
-	lock.Lock()
-	defer lock.Unlock()
-
-	if time.Now().Sub(buildTime) > intervalTime {
-		var err error
-		buildTime = time.Now()
-		os.Chdir(watchPath)
-		cmdName := "go"
+func getCurrentDirectory() string {
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		log.Fatalln(err)
+		os.Exit(1)
+	}
+	return strings.Replace(dir, "\\", "/", -1)
+}
 
-		binName := appName
-		if runtime.GOOS == "windows" {
-			binName += ".exe"
-		}
-		args := []string{"build"}
+// defaultConfig is used by run, build and doctor without -c when it
+// exists in the project directory, init writes it.
+const defaultConfig = "goautobuild.json"
+
+// commands are the subcommands, run is the default.
+var commands = []struct{ name, help string }{
+	{"run", "监听、编译并运行各目标(默认) / watch, build and run the targets"},
+	{"build", "按配置编译一次所有目标后退出 / build every target once and exit"},
+	{"init", "查找项目中的 main 包，生成带注释的配置文件 / write a commented config file"},
+	{"doctor", "检查 Go 工具链、inotify 限制、配置和端口占用 / check the environment"},
+	{"version", "显示版本和编译信息 / print version and build information"},
+}
 
-		if mod != "" {
-			args = append(args, "-mod", mod)
-		}
+func usage() {
+	w := os.Stderr
+	fmt.Fprintf(w, "goautobuild %s\n\nUsage:\n  goautobuild [command] [flags]\n\nCommands:\n", shortVersion())
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(w, "\n运行 goautobuild <command> -help 查看各命令的参数 / run goautobuild <command> -help for its flags\n")
+}
 
-		args = append(args, "-o", binName)
+func main() {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-		cmd := exec.Command(cmdName, args...)
-		cmd.Env = append(os.Environ(), "GOGC=off")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		log.Println("[INFO] Start building...")
-		err = cmd.Run()
+	switch name {
+	case "run":
+		runCommand(args)
+	case "build":
+		buildCommand(args)
+	case "init":
+		initCommand(args)
+	case "doctor":
+		doctorCommand(args)
+	case "version":
+		fmt.Println(versionInfo())
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+}
 
-		if err != nil {
-			log.Println("[ERROR]================Build failed=================")
-			return
-		}
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of goautobuild %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-		log.Println("[SUCCESS] Build success")
-		restart(binName)
+// parseFlags parses args and handles -help.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if printHelp {
+		fmt.Fprintf(fs.Output(), "version: %s\n", shortVersion())
+		fs.Usage()
+		os.Exit(0)
 	}
 }
 
-func restart(binName string) {
-	log.Println("[INFO] Kill running process")
-	kill()
-	go start(binName)
+// projectFlags are the flags of every command that loads the project.
+func projectFlags(fs *flag.FlagSet) {
+	fs.StringVar(&watchPathArg, "d", "./", "工作目录，其中的文件变化会被监听 / project directory, changes below it are watched.eg:/project")
+	fs.StringVar(&configArg, "c", "", "配置文件路径，可定义多个编译运行目标，默认为项目目录下的 goautobuild.json / config file with one or more targets")
+	fs.BoolVar(&printHelp, "help", false, "显示帮助信息 / show this help")
+	fs.StringVar(&mod, "mod", "", "传给 go build 的 -mod / -mod passed to go build.eg:vendor")
+	fs.StringVar(&envArg, "env", "", "运行时的环境变量，多个用逗号分隔 / run environment, comma separated.eg:APP_ENV=dev,PORT=8080")
+	fs.StringVar(&envFileArg, "envfile", "", "运行时加载的环境变量文件，文件变化时自动重启 / env files of the run, a change restarts.eg:.env")
+	fs.StringVar(&buildEnvArg, "buildenv", "", "编译时的环境变量，多个用逗号分隔 / build environment, comma separated.eg:CGO_ENABLED=0")
+	fs.StringVar(&profileArg, "profile", "", "编译配置：dev、race、cover、release，默认 dev / build profile, dev by default")
+	fs.StringVar(&execArg, "exec", "", "每次变化时执行一次的命令，代替编译运行程序，新的变化会中止正在执行的命令 / command run once per change instead of building, a new change cancels it.eg:'go vet ./...'")
+	fs.StringVar(&historyArg, "history", "", "退出时导出编译记录，.csv 结尾为 CSV 格式，否则为 JSON / export the build history on exit, CSV for .csv, JSON otherwise.eg:builds.json")
+	fs.StringVar(&eventsArg, "events", "", "输出事件流，多个用逗号分隔：json(NDJSON 输出到 stdout，程序输出改到 stderr)、unix:套接字路径、sse:监听地址(访问 /events) / event streams: json on stdout, unix:path, sse:addr.eg:json,sse:127.0.0.1:9200")
 }
 
-func kill() {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("[ERROR] Kill failed recover -> ", err)
-		}
-	}()
-	log.Println("[INFO] Killing process")
+// watchFlags are the flags of watching, running and supervising.
+func watchFlags(fs *flag.FlagSet) {
+	fs.StringVar(&watchExtsArg, "e", "", "监听的文件类型，默认监听所有文件类型 / file extensions that trigger a build, all by default.eg：'.go','.html','.php'")
+	fs.StringVar(&ignoreDirArg, "i", "", "忽略监听的目录，多个用逗号分隔 / directories not watched, comma separated")
+	fs.StringVar(&watchDirArg, "w", "", "额外监听的目录，多个用逗号分隔 / extra directories to watch, comma separated")
+	fs.StringVar(&cmdArgs, "args", "", "程序的运行参数 / arguments of the program")
+	fs.StringVar(&watcherArg, "watcher", "auto", "文件监听方式：fsnotify、poll(定时扫描，适用于docker挂载、NFS等)、auto(fsnotify不可用时自动扫描) / watcher backend: fsnotify, poll or auto")
+	fs.DurationVar(&pollInterval, "poll", time.Second, "poll方式的扫描间隔 / scan interval of poll")
+	fs.BoolVar(&pollHash, "pollhash", false, "poll方式同时比较文件内容 / poll compares contents too")
+	fs.DurationVar(&batchDelay, "delay", 300*time.Millisecond, "文件变化后等待的时间，期间的变化合并为一次编译 / changes within this delay are built together")
+	fs.BoolVar(&compareContent, "hash", true, "比较文件内容，内容没有变化时不重新编译 / skip builds when the contents did not change")
+	fs.BoolVar(&followSymlinks, "symlink", false, "监听软链接指向的目录 / watch the directories symlinks point to")
+	fs.StringVar(&modSyncArg, "modsync", "", "go.mod、go.sum、vendor/modules.txt 变化后编译前执行的命令，可选 tidy,download,vendor / go mod steps run after module files changed")
+	fs.StringVar(&debugArg, "debug", "", "以调试模式运行，dlv 的监听地址 / run under a headless dlv listening on this address.eg:127.0.0.1:2345")
+	fs.StringVar(&metricsArg, "metrics", "", "Prometheus 指标的监听地址，访问 /metrics 获取 / serve Prometheus metrics on /metrics.eg:127.0.0.1:9100")
+	fs.StringVar(&notifyArg, "notify", "", "编译失败、恢复、进程崩溃时的通知方式：desktop(桌面通知)、terminal(终端响铃及OSC 9)，多个用逗号分隔 / notifiers: desktop, terminal")
+	fs.StringVar(&webhookArg, "webhook", "", "以 JSON 格式 POST 通知的地址 / webhook receiving the notifications as JSON.eg:http://127.0.0.1:8080/hook")
+	fs.StringVar(&notifyOnArg, "notifyon", "", "需要通知的事件：failed,recovered,crashed,ready，默认 failed,recovered,crashed / events to notify")
+	fs.BoolVar(&tuiArg, "tui", false, "全屏界面，显示各目标的状态、日志和编译错误，可按键重新编译、重启、暂停 / full-screen dashboard of the targets")
+	fs.BoolVar(&affectedOnly, "affected", true, "只在变化的文件属于目标包的依赖时重新编译 / build only when an imported package changed")
+}
 
-	if cmd != nil && cmd.Process != nil {
-		err := cmd.Process.Kill()
-		if err != nil {
-			fmt.Println("[ERROR] Kill process -> ", err)
+// loadProject resolves the directories, changes into the project
+// directory and builds the engine options from the config and the flags
+// set in fs.
+func loadProject(fs *flag.FlagSet) (autobuild.Options, error) {
+	var err error
+	if ignoreDirArg != "" {
+		ignoreDirArr = strings.Split(ignoreDirArg, ",")
+		for k, v := range ignoreDirArr {
 
+			ignoreDirArr[k], err = filepath.Abs(filepath.Clean(v))
+			if err != nil {
+				return autobuild.Options{}, err
+			}
+			log.Println("[INFO] ignore:", ignoreDirArr[k])
 		}
-		log.Println("[SUCCESS] Kill process success")
 
 	}
-	log.Println("[info] this process is nil")
-
-}
 
-func start(binName string) {
-	log.Printf("[INFO] Restarting %s %s ...\n", binName, cmdArgs)
-	binName = "./" + binName
-	cmd = exec.Command(binName, cmdArgsArr...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), "")
-	go cmd.Run()
-	log.Printf("[INFO] %s is running...\n", binName)
-}
+	for _, v := range splitList(watchDirArg) {
+		wp, err := filepath.Abs(filepath.Clean(v))
+		if err != nil {
+			return autobuild.Options{}, err
+		}
+		watchDirs = append(watchDirs, wp)
+	}
 
-func getCurrentDirectory() string {
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	watchPath, err = filepath.Abs(filepath.Clean(watchPathArg))
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		return autobuild.Options{}, err
 	}
-	return strings.Replace(dir, "\\", "/", -1)
-}
 
-func addWatch(root string, watcher *fsnotify.Watcher) {
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		for _, v := range ignoreDirArr {
-			if v == path {
-				return errors.New("ignore " + path)
-			}
+	os.Chdir(watchPath)
+
+	extArr := strings.Split(watchExtsArg, ",")
+
+	if configArg == "" {
+		if _, err := os.Stat(defaultConfig); err == nil {
+			log.Printf("[INFO] Using config %s\n", defaultConfig)
+			configArg = defaultConfig
 		}
+	}
 
+	var cfg *autobuild.Config
+	if configArg != "" {
+		cfg, err = autobuild.LoadConfig(configArg)
 		if err != nil {
-			log.Printf("[ERROR] %s", err)
+			return autobuild.Options{}, fmt.Errorf("config -> %v", err)
 		}
-
-		if info.IsDir() {
-			log.Printf("[TRAC] Directory( %s )\n", path)
+		if err := mergeTargetFlags(fs, cfg); err != nil {
+			return autobuild.Options{}, err
 		}
+	} else {
+		cfg = &autobuild.Config{Targets: []autobuild.TargetConfig{{
+			Args:     strings.Fields(cmdArgs),
+			Watch:    autobuild.WatchRule{Exts: extArr},
+			Env:      autobuild.EnvConfig{Vars: splitList(envArg), Files: splitList(envFileArg)},
+			BuildEnv: autobuild.EnvConfig{Vars: splitList(buildEnvArg)},
+			Debug:    debugArg,
+			Exec:     execArg,
+		}}}
+	}
+
+	if profileArg != "" {
+		cfg.Profile = profileArg
+	}
 
-		if err := watcher.Add(path); err != nil {
-			log.Fatalf("[ERROR] Failed to watch directory[ %s ]\n", err)
+	for _, v := range splitList(notifyArg) {
+		switch v {
+		case "desktop":
+			cfg.Notify.Desktop = true
+		case "terminal":
+			cfg.Notify.Terminal = true
+		default:
+			return autobuild.Options{}, fmt.Errorf("unknown notifier %q, want desktop or terminal", v)
 		}
-		return err
-	})
-}
+	}
+	if webhookArg != "" {
+		cfg.Notify.Webhook = webhookArg
+	}
+	if events := splitList(notifyOnArg); len(events) > 0 {
+		cfg.Notify.Events = events
+	}
 
-func removeWatch(root string, watcher *fsnotify.Watcher) {
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	cfg.ModSync = append(cfg.ModSync, splitList(modSyncArg)...)
+
+	return autobuild.Options{
+		Dir:            watchPath,
+		Config:         *cfg,
+		WatchDirs:      watchDirs,
+		Ignore:         ignoreDirArr,
+		Mod:            mod,
+		Watcher:        watcherArg,
+		PollInterval:   pollInterval,
+		PollHash:       pollHash,
+		BatchDelay:     batchDelay,
+		NoContentHash:  !compareContent,
+		FollowSymlinks: followSymlinks,
+		AllChanges:     !affectedOnly,
+	}, nil
+}
 
-		if err != nil {
-			return err
+// mergeTargetFlags applies the target flags set on the command line to
+// every target of the config file: -e and -args replace the settings of
+// the targets, -env, -envfile and -buildenv add to them. -args, -debug
+// and -exec only apply to a config with a single target.
+func mergeTargetFlags(fs *flag.FlagSet, cfg *autobuild.Config) error {
+	var set []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "e", "args", "env", "envfile", "buildenv", "debug", "exec":
+			set = append(set, "-"+f.Name)
 		}
+	})
+	if len(set) == 0 {
+		return nil
+	}
 
-		if info != nil {
-			if info.IsDir() {
-				log.Printf("[TRAC] Directory( %s )\n", path)
+	if len(cfg.Targets) == 0 {
+		cfg.Targets = []autobuild.TargetConfig{{}}
+	}
+	if len(cfg.Targets) > 1 {
+		for _, name := range set {
+			if name == "-args" || name == "-debug" || name == "-exec" {
+				return fmt.Errorf("%s can not be used with the %d targets of %s, set it in the config", name, len(cfg.Targets), configArg)
 			}
 		}
+	}
 
-		if err := watcher.Remove(path); err != nil {
-			log.Fatalf("[ERROR] Failed to remove watch [ %s ]\n", err)
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		for _, name := range set {
+			switch name {
+			case "-e":
+				t.Watch.Exts = splitList(watchExtsArg)
+			case "-args":
+				t.Args = strings.Fields(cmdArgs)
+			case "-env":
+				t.Env.Vars = append(t.Env.Vars, splitList(envArg)...)
+			case "-envfile":
+				t.Env.Files = append(t.Env.Files, splitList(envFileArg)...)
+			case "-buildenv":
+				t.BuildEnv.Vars = append(t.BuildEnv.Vars, splitList(buildEnvArg)...)
+			case "-debug":
+				t.Debug = debugArg
+			case "-exec":
+				t.Exec = execArg
+			}
 		}
-		return err
-	})
+	}
+	log.Printf("[INFO] Apply %s to the targets of %s\n", strings.Join(set, " "), configArg)
+	return nil
 }
 
-func main() {
-	var err error
-
-	flag.StringVar(&watchPathArg, "d", "./", "工作目录，默认当前目录.eg:/project，处在工作目录的文件会被自动监控变化")
-	flag.StringVar(&watchExtsArg, "e", "", "监听的文件类型，默认监听所有文件类型.eg：'.go','.html','.php'")
-	flag.StringVar(&ignoreDirArg, "i", "", "忽略监听的目录")
-	flag.BoolVar(&printHelp, "help", false, "显示帮助信息")
-	flag.StringVar(&cmdArgs, "args", "", "自定义命令参数")
-	flag.StringVar(&mod, "mod", "", "指定mod使用的vendor")
-	flag.StringVar(&watchDirArg, "w", "", "监听的目录")
-	flag.Parse()
+// runCommand watches the project, builds and runs the targets.
+func runCommand(args []string) {
+	fs := newFlagSet("run")
+	projectFlags(fs)
+	watchFlags(fs)
+	parseFlags(fs, args)
 
-	if printHelp {
-		fmt.Fprintf(flag.CommandLine.Output(), "version: %s\n", "0.5.0")
-		flag.Usage()
-		return
+	opts, err := loadProject(fs)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
 	}
 
-	cmdArgsArr = strings.Split(cmdArgs, " ")
-
-	if ignoreDirArg != "" {
-		ignoreDirArr = strings.Split(ignoreDirArg, ",")
-		for k, v := range ignoreDirArr {
+	streams, err := parseEventStreams(eventsArg)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	// keep stdout for the events
+	if streams.json {
+		opts.Stdout = os.Stderr
+	}
 
-			ignoreDirArr[k], err = filepath.Abs(filepath.Clean(v))
-			if err != nil {
-				log.Fatalf("[FATAL] %v", err)
-			}
-			log.Println("[INFO] ignore:", ignoreDirArr[k])
+	var dash *dashboard
+	if tuiArg {
+		if streams.json {
+			log.Fatal("[FATAL] -tui can not be used with -events json")
 		}
-
+		dash = newDashboard()
+		opts.Output = dash.output
+		opts.Log = dash.logger
+		opts.Stdout = dash.engineOutput()
+		opts.Stderr = opts.Stdout
 	}
 
-	watchPath, err = filepath.Abs(filepath.Clean(watchPathArg))
+	engine, err := autobuild.New(opts)
 	if err != nil {
 		log.Fatalf("[FATAL] %v", err)
 	}
 
-	extArr := strings.Split(watchExtsArg, ",")
-
-	if len(extArr) > 0 {
+	stopEvents, err := streams.start(engine)
+	if err != nil {
+		log.Fatalf("[FATAL] events -> %v", err)
+	}
 
-		for _, v := range extArr {
-			if strings.TrimSpace(v) != "" {
-				extMap[v] = true
-			}
+	ctx, cancel := context.WithCancel(context.Background())
+	go listenSignal(cancel)
+	if dash != nil {
+		if err := dash.start(engine, cancel); err != nil {
+			log.Fatalf("[FATAL] tui -> %v", err)
 		}
+	} else {
+		go control(os.Stdin, engine)
 	}
 
-	go listenSignal(func() {
-		kill()
-	})
+	if metricsArg != "" {
+		go serveMetrics(metricsArg, engine.MetricsHandler())
+	}
 
-	watcher, err := fsnotify.NewWatcher()
+	err = engine.Run(ctx)
+	if dash != nil {
+		dash.close()
+	}
 	if err != nil {
-		log.Fatalf("[FATAL] watcher -> %s", err)
+		log.Fatalf("[FATAL] %v", err)
 	}
 
-	defer watcher.Close()
+	stopEvents()
+	exportHistory(engine)
+	log.Println("[INFO] All processes stopped, exit")
+}
 
-	done := make(chan bool)
+// buildCommand builds every target once, the exit status tells whether
+// all of them succeeded.
+func buildCommand(args []string) {
+	fs := newFlagSet("build")
+	projectFlags(fs)
+	parseFlags(fs, args)
 
-	go func() {
+	opts, err := loadProject(fs)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
 
-		for {
-			select {
-			case event := <-watcher.Events:
+	streams, err := parseEventStreams(eventsArg)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if streams.json {
+		opts.Stdout = os.Stderr
+	}
 
-				if !checkFile(event.Name) || filepath.Base(event.Name) == appName {
-					continue
-				}
+	engine, err := autobuild.New(opts)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
 
-				if event.Op == fsnotify.Write {
-					log.Println("[INFO] Write: ", event.Name)
-					go autobuild()
-				}
+	stopEvents, err := streams.start(engine)
+	if err != nil {
+		log.Fatalf("[FATAL] events -> %v", err)
+	}
 
-				if event.Op == fsnotify.Create {
-					log.Println("[INFO] Create: ", event.Name)
-					addWatch(event.Name, watcher)
-					go autobuild()
-				}
+	ctx, cancel := context.WithCancel(context.Background())
+	go listenSignal(cancel)
 
-				if event.Op == fsnotify.Remove || event.Op == fsnotify.Rename {
-					log.Println("[INFO] Remove: ", event.Name)
-					removeWatch(event.Name, watcher)
-					go autobuild()
-				}
+	err = engine.Build(ctx)
+	stopEvents()
+	exportHistory(engine)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	log.Println("[SUCCESS] All targets built")
+}
 
-			case err := <-watcher.Errors:
-				log.Println("[ERROR] watcher error:", err)
-			}
-		}
-	}()
-
-	var watchDir []string
-	watchDir = append(watchDir, watchPath)
-	if watchDirArg != "" {
-		elems := strings.Split(watchDirArg, ",")
-		for _, v := range elems {
-			if v == "" {
-				continue
-			}
-			wp, err := filepath.Abs(filepath.Clean(v))
-			if err != nil {
-				log.Fatalf("[FATAL] %v", err)
-			}
-			watchDir = append(watchDir, wp)
-		}
+func exportHistory(e *autobuild.Engine) {
+	if historyArg == "" {
+		return
 	}
-	for _, v := range watchDir {
-		log.Println("[INFO] watch", v, ",file ext", extArr)
-		err = watcher.Add(v)
-		if err != nil {
-			log.Fatalf("[FATAL] watcher -> %v", err)
-		}
-		addWatch(v, watcher)
+	if err := e.ExportHistory(historyArg); err != nil {
+		log.Printf("[ERROR] Export history -> %v\n", err)
 	}
+}
 
-	autobuild()
-	<-done
+func serveMetrics(addr string, h http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	log.Printf("[INFO] Serving metrics on http://%s/metrics\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("[ERROR] metrics -> %v\n", err)
+	}
 }
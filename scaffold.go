@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// initCommand writes a commented config file with a target for every
+// main package of the project.
+func initCommand(args []string) {
+	var dirArg, output string
+	var force bool
+	fs := newFlagSet("init")
+	fs.StringVar(&dirArg, "d", "./", "项目目录，默认当前目录 / project directory")
+	fs.StringVar(&output, "o", defaultConfig, "生成的配置文件，相对于项目目录 / config file to write, relative to the project")
+	fs.BoolVar(&force, "force", false, "覆盖已存在的配置文件 / overwrite an existing config file")
+	fs.BoolVar(&printHelp, "help", false, "显示帮助信息 / show this help")
+	parseFlags(fs, args)
+
+	dir, err := filepath.Abs(dirArg)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	if _, err := os.Stat(output); err == nil && !force {
+		log.Fatalf("[FATAL] %s exists, use -force to overwrite it", output)
+	}
+
+	pkgs, err := mainPackages(dir)
+	if err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if len(pkgs) == 0 {
+		log.Fatalf("[FATAL] No main package found in %s", dir)
+	}
+
+	if err := ioutil.WriteFile(output, scaffold(dir, pkgs), 0644); err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	for _, p := range pkgs {
+		log.Printf("[INFO] Target %s: %s\n", p.name, p.pkg)
+	}
+	log.Printf("[SUCCESS] Wrote %s, run goautobuild in %s to use it\n", output, dir)
+}
+
+type mainPackage struct {
+	name string
+	pkg  string
+}
+
+// mainPackages lists the main packages below dir, named after their
+// directory.
+func mainPackages(dir string) ([]mainPackage, error) {
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.Name}} {{.Dir}}", "./...")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -> %v", err)
+	}
+
+	var pkgs []mainPackage
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 || fields[0] != "main" {
+			continue
+		}
+		rel, err := filepath.Rel(dir, fields[1])
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		name := filepath.Base(fields[1])
+		if names[name] {
+			name = strings.Replace(rel, "/", "-", -1)
+		}
+		names[name] = true
+
+		pkg := "."
+		if rel != "." {
+			pkg = "./" + rel
+		}
+		pkgs = append(pkgs, mainPackage{name: name, pkg: pkg})
+	}
+	return pkgs, nil
+}
+
+// scaffold is the config for pkgs, the first target explains every
+// setting.
+func scaffold(dir string, pkgs []mainPackage) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// goautobuild 配置文件，由 goautobuild init 根据 %s 中的 main 包生成\n// goautobuild config, written by goautobuild init for the main packages of %[1]s\n", filepath.Base(dir))
+	b.WriteString("// 行尾可以写 // 注释，说明见 https://github.com/iwannay/goautobuild\n")
+	b.WriteString("// line comments are allowed, see https://github.com/iwannay/goautobuild\n")
+	b.WriteString("{\n")
+	b.WriteString("  // 编译运行的目标，共用一个文件监听，只重新编译受影响的目标\n  // the targets share one watcher, a change rebuilds only the targets it affects\n")
+	b.WriteString("  \"targets\": [\n")
+	for i, p := range pkgs {
+		first := i == 0
+		comment := func(s string) {
+			if first {
+				b.WriteString("      // " + s + "\n")
+			}
+		}
+		b.WriteString("    {\n")
+		comment("目标名，作为日志前缀，depends_on 中引用 / name, prefixes the logs and is used by depends_on")
+		fmt.Fprintf(&b, "      \"name\": %s,\n", strconv.Quote(p.name))
+		comment("编译的包，也可以用 build、run 自定义编译和运行命令 / package to build, build and run set custom commands")
+		fmt.Fprintf(&b, "      \"pkg\": %s,\n", strconv.Quote(p.pkg))
+		comment("运行参数 / arguments of the program")
+		b.WriteString("      \"args\": [],\n")
+		comment("exts 为触发编译的文件类型，为空时所有文件都会触发；dirs 为额外监听的目录，ignore 为忽略的目录")
+		comment("exts trigger a build, all files when empty; dirs are watched too, ignore never")
+		b.WriteString("      \"watch\": {\"exts\": [\".go\"], \"dirs\": [], \"ignore\": []},\n")
+		comment("运行时的环境变量，files 中的文件变化时重启 / run environment, a change of files restarts")
+		comment("eg:{\"vars\": [\"PORT=8080\"], \"files\": [\".env\"]}")
+		b.WriteString("      \"env\": {\"vars\": [], \"files\": []},\n")
+		comment("就绪检查，通过后才启动依赖它的目标 / ready check, dependents start once it passes")
+		comment("eg:{\"tcp\": \"127.0.0.1:8080\"}、{\"http\": \"http://127.0.0.1:8080/health\"}")
+		b.WriteString("      \"ready\": {},\n")
+		comment("依赖的目标，在它们就绪后启动 / targets to wait for.eg:[{\"name\": \"db\", \"cascade\": true}]")
+		b.WriteString("      \"depends_on\": []\n")
+		if i < len(pkgs)-1 {
+			b.WriteString("    },\n")
+		} else {
+			b.WriteString("    }\n")
+		}
+	}
+	b.WriteString("  ],\n")
+	b.WriteString("  // 编译配置：dev、race、cover、release，运行时可以输入 profile <name> 切换\n  // build profile: dev, race, cover or release, type profile <name> to switch\n")
+	b.WriteString("  \"profile\": \"dev\"\n")
+	b.WriteString("}\n")
+	return b.Bytes()
+}
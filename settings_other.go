@@ -0,0 +1,9 @@
+//go:build !go1.18
+// +build !go1.18
+
+package main
+
+// buildSetting is empty, the go command records no settings before 1.18.
+func buildSetting(key string) string {
+	return ""
+}
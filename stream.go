@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/iwannay/goautobuild/autobuild"
+)
+
+// eventStreams are the outputs of the -events flag: NDJSON on stdout,
+// unix sockets and Server-Sent Events endpoints.
+type eventStreams struct {
+	json bool
+	unix []string
+	sse  []string
+}
+
+func parseEventStreams(s string) (*eventStreams, error) {
+	streams := &eventStreams{}
+	for _, v := range splitList(s) {
+		switch {
+		case v == "json":
+			streams.json = true
+		case strings.HasPrefix(v, "unix:"):
+			streams.unix = append(streams.unix, strings.TrimPrefix(v, "unix:"))
+		case strings.HasPrefix(v, "sse:"):
+			streams.sse = append(streams.sse, strings.TrimPrefix(v, "sse:"))
+		default:
+			return nil, fmt.Errorf("unknown event stream %q, want json, unix:path or sse:addr", v)
+		}
+	}
+	return streams, nil
+}
+
+// start opens the streams and returns a function that writes the
+// pending events and closes them.
+func (s *eventStreams) start(e *autobuild.Engine) (func(), error) {
+	var closers []func()
+	stop := func() {
+		for _, fn := range closers {
+			fn()
+		}
+	}
+
+	if s.json {
+		events, cancel := e.Subscribe(256)
+		done := make(chan struct{})
+		go func() {
+			autobuild.WriteEvents(os.Stdout, events)
+			close(done)
+		}()
+		closers = append(closers, func() {
+			cancel()
+			<-done
+		})
+	}
+
+	for _, path := range s.unix {
+		// a socket left behind by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		log.Printf("[INFO] Streaming events on unix:%s\n", path)
+		go e.ServeEvents(l)
+		closers = append(closers, func() { l.Close() })
+	}
+
+	for _, addr := range s.sse {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/events", e.EventsHandler())
+		log.Printf("[INFO] Streaming events on http://%s/events\n", addr)
+		go http.Serve(l, mux)
+		closers = append(closers, func() { l.Close() })
+	}
+	return stop, nil
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "fmt"
+
+func termSize() (int, int) {
+	return 80, 24
+}
+
+// cbreak is not implemented on windows.
+func cbreak() (func(), error) {
+	return nil, fmt.Errorf("the dashboard is not supported on windows")
+}
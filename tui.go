@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iwannay/goautobuild/autobuild"
+)
+
+// maxLogLines bounds the lines kept per target by the dashboard.
+const maxLogLines = 2000
+
+// ansiEscape matches the escape sequences of process output, they
+// would break the layout of the dashboard.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07`)
+
+var stateColors = map[autobuild.TargetState]string{
+	autobuild.StateBuilding: "33",
+	autobuild.StateFailed:   "31",
+	autobuild.StateRunning:  "36",
+	autobuild.StateReady:    "32",
+	autobuild.StateCrashed:  "31",
+	autobuild.StatePassed:   "32",
+}
+
+// logRing keeps the last lines written to it.
+type logRing struct {
+	lines   []string
+	partial []byte
+	lock    sync.Mutex
+}
+
+func (r *logRing) Write(b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.partial = append(r.partial, b...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(string(r.partial[:i]))
+		r.partial = r.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (r *logRing) add(line string) {
+	line = ansiEscape.ReplaceAllString(strings.TrimRight(line, "\r"), "")
+	r.lines = append(r.lines, strings.Replace(line, "\t", "    ", -1))
+	if len(r.lines) > maxLogLines {
+		r.lines = r.lines[len(r.lines)-maxLogLines:]
+	}
+}
+
+// tail returns n lines ending skip lines before the last one.
+func (r *logRing) tail(n, skip int) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	end := len(r.lines) - skip
+	if end < 0 {
+		end = 0
+	}
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	return append([]string{}, r.lines[start:end]...)
+}
+
+func (r *logRing) len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.lines)
+}
+
+// dashboard is the full-screen terminal UI of -tui: the status of every
+// target, the log of the selected one and its last build errors.
+type dashboard struct {
+	e       *autobuild.Engine
+	cancel  func()
+	restore func()
+	logs    map[string]*logRing
+	message string
+
+	selected int
+	scroll   int
+	lock     sync.Mutex
+
+	redraw chan struct{}
+	done   chan struct{}
+}
+
+func newDashboard() *dashboard {
+	return &dashboard{
+		logs:   make(map[string]*logRing),
+		redraw: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// output is the Output option of the engine, the build and process
+// output of each target goes to its log pane.
+func (d *dashboard) output(target string) io.Writer {
+	return d.ring(target)
+}
+
+func (d *dashboard) ring(target string) *logRing {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	r := d.logs[target]
+	if r == nil {
+		r = &logRing{}
+		d.logs[target] = r
+	}
+	return r
+}
+
+// engineOutput is the Stdout and Stderr option of the engine: the output
+// of go commands that belong to no target, like go mod and go list. Its
+// lines are handled like log messages of the engine.
+func (d *dashboard) engineOutput() io.Writer {
+	return &lineWriter{d: d}
+}
+
+// logger is the Log option of the engine: the messages of a target go to
+// its pane, those of the engine to the message line.
+func (d *dashboard) logger(target string) *log.Logger {
+	return log.New(&lineWriter{d: d, target: target}, "", log.Ltime)
+}
+
+// lineWriter hands complete lines to the dashboard as messages of target.
+type lineWriter struct {
+	d       *dashboard
+	target  string
+	partial []byte
+	lock    sync.Mutex
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	// terminal notifications are escapes for the terminal itself
+	if bytes.HasPrefix(b, []byte("\a\x1b]")) {
+		return os.Stdout.Write(b)
+	}
+
+	w.lock.Lock()
+	w.partial = append(w.partial, b...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	w.lock.Unlock()
+
+	for _, line := range lines {
+		w.d.logLine(w.target, line)
+	}
+	return len(b), nil
+}
+
+// logLine shows a log line of target in its pane. Lines of the engine
+// go to the message line, or to the pane of the only target if it has
+// no name.
+func (d *dashboard) logLine(target, line string) {
+	line = ansiEscape.ReplaceAllString(line, "")
+	d.lock.Lock()
+	r := d.logs[target]
+	if r == nil && target == "" {
+		d.message = line
+	}
+	d.lock.Unlock()
+
+	if r == nil && target != "" {
+		r = d.ring(target)
+	}
+	if r != nil {
+		r.Write([]byte(line + "\n"))
+	}
+	d.update()
+}
+
+// Write receives what the command line itself logs, it goes to the
+// message line.
+func (d *dashboard) Write(b []byte) (int, error) {
+	line := ansiEscape.ReplaceAllString(strings.TrimRight(string(b), "\n"), "")
+	d.lock.Lock()
+	d.message = line
+	d.lock.Unlock()
+	d.update()
+	return len(b), nil
+}
+
+// start takes over the terminal, cancel is called when the user quits.
+func (d *dashboard) start(e *autobuild.Engine, cancel func()) error {
+	restore, err := cbreak()
+	if err != nil {
+		return err
+	}
+	d.e = e
+	d.cancel = cancel
+	d.restore = restore
+
+	// the engine logs through d.logger, this catches the rest
+	log.SetFlags(log.Ltime)
+	log.SetOutput(d)
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+
+	events, unsubscribe := e.Subscribe(64)
+	go func() {
+		defer unsubscribe()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-events:
+			case <-d.redraw:
+			case <-ticker.C:
+			case <-d.done:
+				return
+			}
+			d.draw()
+		}
+	}()
+	go d.keys(os.Stdin)
+	d.update()
+	return nil
+}
+
+// close gives the terminal back.
+func (d *dashboard) close() {
+	close(d.done)
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	d.restore()
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+}
+
+func (d *dashboard) update() {
+	select {
+	case d.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dashboard) keys(r io.Reader) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		d.key(string(buf[:n]))
+		d.update()
+	}
+}
+
+func (d *dashboard) key(k string) {
+	status := d.e.Status()
+	_, rows := termSize()
+	page := rows / 2
+
+	d.lock.Lock()
+	if d.selected >= len(status) {
+		d.selected = len(status) - 1
+	}
+	cur := status[d.selected]
+	switch k {
+	case "\x1b[A", "k":
+		if d.selected > 0 {
+			d.selected--
+			d.scroll = 0
+		}
+	case "\x1b[B", "j":
+		if d.selected < len(status)-1 {
+			d.selected++
+			d.scroll = 0
+		}
+	case "\x1b[5~", "b":
+		d.scroll += page
+		if max := d.logs[cur.Name].len() - 1; d.scroll > max {
+			d.scroll = max
+		}
+	case "\x1b[6~", " ":
+		d.scroll -= page
+		if d.scroll < 0 {
+			d.scroll = 0
+		}
+	case "G":
+		d.scroll = 0
+	}
+	d.lock.Unlock()
+
+	// the engine logs, which takes the lock again
+	switch k {
+	case "r":
+		d.e.RebuildTarget(cur.Name)
+	case "R":
+		d.e.Rebuild()
+	case "s":
+		d.e.Restart(cur.Name)
+	case "p":
+		d.e.Pause(cur.Name, !cur.Paused)
+	case "q":
+		d.cancel()
+	}
+}
+
+func (d *dashboard) draw() {
+	cols, rows := termSize()
+	status := d.e.Status()
+	profile, _ := d.e.Profile()
+
+	d.lock.Lock()
+	if d.selected >= len(status) {
+		d.selected = len(status) - 1
+	}
+	selected, scroll, message := d.selected, d.scroll, d.message
+	ring := d.logs[status[selected].Name]
+	d.lock.Unlock()
+
+	var lines []string
+	header := fmt.Sprintf("goautobuild  profile %s", profile)
+	lines = append(lines, "\x1b[1m"+pad(header, cols-9)+"\x1b[0m "+time.Now().Format("15:04:05"))
+	lines = append(lines, "\x1b[2m"+pad(fmt.Sprintf("  %-16s %-10s %-8s %-9s %s", "TARGET", "STATE", "PID", "RESTARTS", "LAST BUILD"), cols)+"\x1b[0m")
+
+	for i, s := range status {
+		mark := "  "
+		if i == selected {
+			mark = "> "
+		}
+		state := string(s.State)
+		if s.Paused {
+			state += "*"
+		}
+		pid := "-"
+		if s.PID != 0 {
+			pid = fmt.Sprint(s.PID)
+		}
+		build := "-"
+		if !s.LastBuild.IsZero() {
+			build = fmt.Sprintf("%s (%s)", s.LastBuild.Format("15:04:05"), s.BuildDuration.Round(time.Millisecond))
+		}
+		row := pad(fmt.Sprintf("%s%-16s %-10s %-8s %-9d %s", mark, autobuild.TargetLabel(s.Name), state, pid, s.Restarts, build), cols)
+		if color := stateColors[s.State]; color != "" {
+			row = "\x1b[" + color + "m" + row + "\x1b[0m"
+		}
+		if i == selected {
+			row = "\x1b[7m" + row + "\x1b[0m"
+		}
+		lines = append(lines, row)
+	}
+
+	cur := status[selected]
+	if cur.State == autobuild.StateFailed {
+		lines = append(lines, rule("errors", cols))
+		errs := buildErrors(cur)
+		if len(errs) > 5 {
+			errs = append(errs[:4], fmt.Sprintf("... %d more", len(errs)-4))
+		}
+		for _, v := range errs {
+			lines = append(lines, "\x1b[31m"+pad(v, cols)+"\x1b[0m")
+		}
+	}
+
+	title := autobuild.TargetLabel(cur.Name) + " log"
+	if cur.Paused {
+		title += ", paused"
+	}
+	if scroll > 0 {
+		title += fmt.Sprintf(", %d lines up", scroll)
+	}
+	lines = append(lines, rule(title, cols))
+
+	// the log pane takes the rest of the screen above the two last lines
+	height := rows - len(lines) - 2
+	var logLines []string
+	if ring != nil && height > 0 {
+		logLines = ring.tail(height, scroll)
+	}
+	for i := 0; i < height; i++ {
+		v := ""
+		if i < len(logLines) {
+			v = logLines[i]
+		}
+		lines = append(lines, pad(v, cols))
+	}
+
+	lines = append(lines, "\x1b[2m"+pad(message, cols)+"\x1b[0m")
+	lines = append(lines, pad("↑↓ select  r rebuild  R rebuild all  s restart  p pause  PgUp/PgDn scroll  G follow  q quit", cols))
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, v := range lines {
+		b.WriteString(v)
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\x1b[J")
+	os.Stdout.WriteString(b.String())
+}
+
+// buildErrors are the lines describing the last failed build.
+func buildErrors(s autobuild.TargetStatus) []string {
+	if len(s.Errors) == 0 {
+		return []string{"build " + s.Error}
+	}
+	wd, _ := os.Getwd()
+	var list []string
+	for _, d := range s.Errors {
+		file := d.File
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+		}
+		msg := strings.SplitN(d.Message, "\n", 2)[0]
+		list = append(list, fmt.Sprintf("%s:%d:%d: %s", file, d.Line, d.Column, msg))
+	}
+	return list
+}
+
+func rule(title string, cols int) string {
+	return "\x1b[2m" + pad("── "+title+" "+strings.Repeat("─", cols), cols) + "\x1b[0m"
+}
+
+// pad cuts or fills s to n columns.
+func pad(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
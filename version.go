@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version can be set at link time with -ldflags "-X main.version=v1.0.0",
+// otherwise it is the module version of the build.
+var version string
+
+// shortVersion is the version with the commit of development builds.
+func shortVersion() string {
+	v := version
+	info, ok := debug.ReadBuildInfo()
+	if v == "" && ok {
+		v = info.Main.Version
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	if rev := buildSetting("vcs.revision"); rev != "" && v == "(devel)" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		v += " " + rev
+	}
+	return v
+}
+
+// versionInfo describes the binary: version, module, commit and the Go
+// version it was built with.
+func versionInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "goautobuild %s\n", shortVersion())
+	fmt.Fprintf(&b, "  go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "  module:   %s\n", info.Main.Path)
+		if info.Main.Sum != "" {
+			fmt.Fprintf(&b, "  sum:      %s\n", info.Main.Sum)
+		}
+	}
+	if rev := buildSetting("vcs.revision"); rev != "" {
+		if buildSetting("vcs.modified") == "true" {
+			rev += " (modified)"
+		}
+		fmt.Fprintf(&b, "  commit:   %s\n", rev)
+	}
+	if t := buildSetting("vcs.time"); t != "" {
+		fmt.Fprintf(&b, "  time:     %s\n", t)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}